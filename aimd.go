@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/rpc"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// aimdStart is where the adaptive limit begins, a free tier provider takes that much
+const aimdStart = 8
+
+// aimdController adapts how many fetch jobs run at once. The limit grows by one after a limit worth of fast
+// successful calls and halves on a call that hit rate limits, a timeout or a reset connection. Calls slower than
+// twice the usual latency of their kind hold the limit where it is. Nil controller puts no limit.
+type aimdController struct {
+	max int
+
+	mut      sync.Mutex
+	limit    int
+	inFlight int
+	// wake is closed when a job finishes or the limit grows
+	wake chan struct{}
+	// successes counts fast calls since the limit last grew
+	successes int
+	// latency is the moving average of successful calls by their kind, see callKind
+	latency map[string]time.Duration
+	// calls started before the last decrease ran at the old limit, their failures are no news
+	lastDecrease time.Time
+}
+
+// latencyWeight is the share of a new call in the moving average of latency, older calls fade out with it
+const latencyWeight = 0.2
+
+func newAIMDController(max int) *aimdController {
+	limit := aimdStart
+	if limit > max {
+		limit = max
+	}
+	return &aimdController{max: max, limit: limit, wake: make(chan struct{}), latency: make(map[string]time.Duration)}
+}
+
+// current is the limit in effect
+func (c *aimdController) current() int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.limit
+}
+
+// acquire waits until a job can start
+func (c *aimdController) acquire(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mut.Lock()
+		if c.inFlight < c.limit {
+			c.inFlight++
+			c.mut.Unlock()
+			return nil
+		}
+		wake := c.wake
+		c.mut.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *aimdController) release() {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.inFlight--
+	c.notify()
+}
+
+func (c *aimdController) notify() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// observe takes the outcome of a single call or batch started at start, what names it
+func (c *aimdController) observe(what string, start time.Time, err error) {
+	took := time.Since(start)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if err != nil {
+		if isCongestion(err) && start.After(c.lastDecrease) {
+			c.limit /= 2
+			if c.limit < 1 {
+				c.limit = 1
+			}
+			c.successes = 0
+			c.lastDecrease = time.Now()
+		}
+		return
+	}
+	kind := callKind(what)
+	usual, seen := c.latency[kind]
+	if !seen {
+		usual = took
+	}
+	c.latency[kind] = usual + time.Duration(latencyWeight*float64(took-usual))
+	if took > 2*usual {
+		c.successes = 0
+		return
+	}
+	c.successes++
+	if c.successes >= c.limit && c.limit < c.max {
+		c.limit++
+		c.successes = 0
+		c.notify()
+	}
+}
+
+// callKind is what without block numbers, hashes and alike, calls of the same kind take about the same time.
+// Headers, receipts and batches of them each get a latency of their own.
+func callKind(what string) string {
+	if i := strings.IndexAny(what, "0123456789"); i >= 0 {
+		return what[:i]
+	}
+	return what
+}
+
+// isCongestion tells err is the provider or the network being overloaded rather than a bad request
+func isCongestion(err error) bool {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode == 503
+	}
+	var rpcErr rpc.Error
+	// -32005 is the limit exceeded code of EIP-1474
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32005 {
+		return true
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too many requests") || strings.Contains(msg, "rate limit") ||
+		strings.Contains(msg, "connection reset")
+}
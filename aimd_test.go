@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAIMDMixedCalls grows the limit when calls of different kinds alternate, each as fast as usual for its kind,
+// and after latency settles at a new level. Congestion halves it.
+func TestAIMDMixedCalls(t *testing.T) {
+	c := newAIMDController(32)
+	for i := 0; i < 1000; i++ {
+		c.observe("batch of eth_getBlockByNumber", time.Now().Add(-10*time.Millisecond), nil)
+		c.observe("batch of eth_getBlockReceipts", time.Now().Add(-50*time.Millisecond), nil)
+	}
+	if c.current() != 32 {
+		t.Fatalf("limit %d after alternating calls, want 32", c.current())
+	}
+
+	c.observe("block 100", time.Now(), errors.New("429 Too Many Requests"))
+	if c.current() != 16 {
+		t.Fatalf("limit %d after congestion, want 16", c.current())
+	}
+	// provider got slower for good, the usual latency follows
+	for i := 0; i < 1000; i++ {
+		c.observe("batch of eth_getBlockByNumber", time.Now().Add(-40*time.Millisecond), nil)
+	}
+	if c.current() != 32 {
+		t.Errorf("limit %d after latency settled, want 32", c.current())
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batcher sends calls as JSON-RPC batches of up to size calls. Providers cap batch size, some by rejecting the
+// whole batch, some by failing the calls over the limit. Either way rejected calls are sent again in batches half
+// the size, down to single calls, and the smaller size holds for the rest of the scan. Calls failing otherwise are
+// sent again at the same size, as policy says.
+type batcher struct {
+	client *rpc.Client
+	policy retryPolicy
+
+	mut  sync.Mutex
+	size int
+}
+
+func newBatcher(client *rpc.Client, policy retryPolicy, size int) *batcher {
+	return &batcher{client: client, policy: policy, size: size}
+}
+
+func (b *batcher) batchSize() int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.size
+}
+
+// shrink halves batch size after a batch of size calls was rejected for its size. Batches in flight get rejected
+// together, only the first of them shrinks.
+func (b *batcher) shrink(size int) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if size <= b.size && b.size > 1 {
+		b.size = size / 2
+		if b.size < 1 {
+			b.size = 1
+		}
+		fmt.Printf("warning: RPC batch of %d calls too large, continuing with batches of %d\n", size, b.size)
+	}
+}
+
+// call runs all calls, results go to Result of each one. Calls are retried as policy says, failing one fails the
+// whole call.
+func (b *batcher) call(ctx context.Context, calls []rpc.BatchElem) error {
+	pending := append([]rpc.BatchElem{}, calls...)
+	for len(pending) > 0 {
+		size := b.batchSize()
+		if size > len(pending) {
+			size = len(pending)
+		}
+		chunk := pending[:size]
+		if size == 1 {
+			elem := chunk[0]
+			err := b.policy.do(ctx, fmt.Sprintf("%s %v", elem.Method, elem.Args), func(ctx context.Context) error {
+				return b.client.CallContext(ctx, elem.Result, elem.Method, elem.Args...)
+			})
+			if err != nil {
+				return err
+			}
+			pending = pending[1:]
+			continue
+		}
+
+		rejected, err := b.send(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		if len(rejected) > 0 {
+			b.shrink(size)
+		}
+		pending = append(rejected, pending[size:]...)
+	}
+	return nil
+}
+
+// send runs chunk as a batch. Calls failing for other reasons than batch size are sent again in a batch of their
+// own, after a delay as policy says. Calls rejected for batch size are returned.
+func (b *batcher) send(ctx context.Context, chunk []rpc.BatchElem) ([]rpc.BatchElem, error) {
+	rejected := make([]rpc.BatchElem, 0)
+	size := len(chunk)
+	delay := b.policy.baseDelay
+	var err error
+	for attempt := 1; attempt <= b.policy.attempts; attempt++ {
+		if attempt > 1 {
+			if b.policy.wait(ctx, delay) != nil {
+				return nil, ctx.Err()
+			}
+			delay = b.policy.nextDelay(delay)
+		}
+		start := time.Now()
+		callCtx, cancel := context.WithTimeout(ctx, b.policy.timeout)
+		err = b.client.BatchCallContext(callCtx, chunk)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			if isBatchTooLarge(err) {
+				return append(rejected, chunk...), nil
+			}
+			b.observe(chunk, start, err)
+			continue
+		}
+		failed := make([]rpc.BatchElem, 0)
+		var failure error
+		sized := len(rejected)
+		for _, elem := range chunk {
+			if elem.Error == nil {
+				continue
+			}
+			if isBatchTooLarge(elem.Error) {
+				elem.Error = nil
+				rejected = append(rejected, elem)
+				continue
+			}
+			failure = elem.Error
+			elem.Error = nil
+			failed = append(failed, elem)
+		}
+		// calls rejected for batch size say nothing about load of the provider
+		if len(rejected)-sized < len(chunk) {
+			b.observe(chunk, start, failure)
+		}
+		err = failure
+		if len(failed) == 0 {
+			return rejected, nil
+		}
+		chunk = failed
+	}
+	return nil, fmt.Errorf("failed to fetch batch of %d calls after %d attempts: %w", size, b.policy.attempts, err)
+}
+
+// observe reports the outcome of a batch to policy, the way policy.do does for single calls. Batches are named
+// by their method.
+func (b *batcher) observe(chunk []rpc.BatchElem, start time.Time, err error) {
+	if b.policy.observe != nil {
+		b.policy.observe("batch of "+chunk[0].Method, start, err)
+	}
+}
+
+// isBatchTooLarge tells err is the provider turning down a batch, or calls of it, for the size of the batch. A
+// batch answered with a single error object gets that error for each of its calls, see batchErrorTransport.
+// Rate limits and other errors naming the batch don't count.
+func isBatchTooLarge(err error) bool {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusRequestEntityTooLarge
+	}
+	msg := strings.ToLower(err.Error())
+	if !strings.Contains(msg, "batch") || strings.Contains(msg, "rate") {
+		return false
+	}
+	for _, sign := range []string{"too large", "too big", "too many", "size", "limit", "exceed", "maximum"} {
+		if strings.Contains(msg, sign) {
+			return true
+		}
+	}
+	return false
+}
+
+// batchErrorTransport hands out a batch answered with a single error object as that error for each call of the
+// batch. The rpc client fails to decode such an answer and drops the error, whose code and message tell a batch
+// too large from a rate limit.
+type batchErrorTransport struct {
+	transport http.RoundTripper
+}
+
+func (t batchErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var calls []struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		// calls stay empty for a single call
+		_ = json.NewDecoder(body).Decode(&calls)
+		_ = body.Close()
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || len(calls) == 0 || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var answer struct {
+		Error json.RawMessage `json:"error"`
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' && json.Unmarshal(raw, &answer) == nil && len(answer.Error) > 0 {
+		resps := make([]map[string]json.RawMessage, len(calls))
+		for i, call := range calls {
+			resps[i] = map[string]json.RawMessage{"jsonrpc": json.RawMessage(`"2.0"`), "id": call.ID, "error": answer.Error}
+		}
+		raw, err = json.Marshal(resps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	resp.ContentLength = int64(len(raw))
+	return resp, nil
+}
+
+// dialRPC dials url, http and https endpoints go through batchErrorTransport
+func dialRPC(url string) (*rpc.Client, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return rpc.Dial(url)
+	}
+	return rpc.DialHTTPWithClient(url, &http.Client{Transport: batchErrorTransport{transport: http.DefaultTransport}})
+}
+
+// rpcBlockHashes is a block as returned by eth_getBlockByNumber without transaction bodies
+type rpcBlockHashes struct {
+	Number       hexutil.Uint64 `json:"number"`
+	Hash         common.Hash    `json:"hash"`
+	LogsBloom    types.Bloom    `json:"logsBloom"`
+	Transactions []common.Hash  `json:"transactions"`
+}
+
+// fetchBlocksBatched is fetchBlock for many blocks at once. Blocks come without transaction bodies, transactions
+// whose receipt holds a log of filter are fetched when calldata is wanted.
+func (f *fetcher) fetchBlocksBatched(ctx context.Context, blocks []uint64, agreed []common.Hash, filter common.Address) ([]fetchBlockOutput, error) {
+	b := f.batch
+	headers := make([]*rpcBlockHashes, len(blocks))
+	calls := make([]rpc.BatchElem, len(blocks))
+	for i, block := range blocks {
+		calls[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(block), false},
+			Result: &headers[i],
+		}
+	}
+	err := b.call(ctx, calls)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]fetchBlockOutput, len(blocks))
+	// same as in fetchBlock, bloom rules out blocks without a deposit log
+	hashes := make([]common.Hash, 0)
+	owners := make([]int, 0)
+	candidates := make([]int, 0)
+	for i, header := range headers {
+		if header == nil || uint64(header.Number) != blocks[i] {
+			return nil, fmt.Errorf("block %d not found", blocks[i])
+		}
+		err = checkAgreedBlock(blocks[i], agreed[i], header.Hash, nil)
+		if err != nil {
+			return nil, err
+		}
+		output[i] = fetchBlockOutput{block: blocks[i], data: make([]fetchBlockEntry, 0)}
+		if !mayHoldDeposits(header.LogsBloom, filter) {
+			continue
+		}
+		candidates = append(candidates, i)
+		for _, hash := range header.Transactions {
+			hashes = append(hashes, hash)
+			owners = append(owners, i)
+		}
+	}
+	if len(hashes) == 0 {
+		return output, nil
+	}
+
+	var receipts []*types.Receipt
+	if f.blockReceipts {
+		blockReceipts := make([][]*types.Receipt, len(candidates))
+		calls = make([]rpc.BatchElem, len(candidates))
+		for i, idx := range candidates {
+			calls[i] = rpc.BatchElem{
+				Method: "eth_getBlockReceipts",
+				Args:   []interface{}{hexutil.EncodeUint64(blocks[idx])},
+				Result: &blockReceipts[i],
+			}
+		}
+		err = b.call(ctx, calls)
+		if err != nil {
+			return nil, err
+		}
+		// candidates are in block order, so are hashes
+		receipts = make([]*types.Receipt, 0, len(hashes))
+		for i, idx := range candidates {
+			err = checkBlockReceipts(blocks[idx], headers[idx].Transactions, blockReceipts[i])
+			if err != nil {
+				return nil, err
+			}
+			err = checkAgreedBlock(blocks[idx], agreed[idx], headers[idx].Hash, blockReceipts[i])
+			if err != nil {
+				return nil, err
+			}
+			receipts = append(receipts, blockReceipts[i]...)
+		}
+	} else {
+		receipts = make([]*types.Receipt, len(hashes))
+		calls = make([]rpc.BatchElem, len(hashes))
+		for i, hash := range hashes {
+			calls[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{hash}, Result: &receipts[i]}
+		}
+		err = b.call(ctx, calls)
+		if err != nil {
+			return nil, err
+		}
+		for i, rcpt := range receipts {
+			if rcpt == nil {
+				return nil, fmt.Errorf("receipt of tx %s not found", hashes[i].Hex())
+			}
+			owner := owners[i]
+			err = checkAgreedBlock(blocks[owner], agreed[owner], headers[owner].Hash, []*types.Receipt{rcpt})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	matched := make([]int, 0)
+	for i, rcpt := range receipts {
+		if rcpt.Status == 1 && hasLogFrom(rcpt.Logs, filter) {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		return output, nil
+	}
+
+	// transactions are only wanted for calldata cross-check
+	txs := make([]*rpcTxCalldata, len(matched))
+	if f.calldata {
+		calls = make([]rpc.BatchElem, len(matched))
+		for i, idx := range matched {
+			calls[i] = rpc.BatchElem{Method: "eth_getTransactionByHash", Args: []interface{}{hashes[idx]}, Result: &txs[i]}
+		}
+		err = b.call(ctx, calls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, idx := range matched {
+		entry := fetchBlockEntry{hash: hashes[idx], logs: receipts[idx].Logs}
+		if f.calldata {
+			if txs[i] == nil {
+				return nil, fmt.Errorf("tx %s not found", hashes[idx].Hex())
+			}
+			entry.to, entry.input = txs[i].To, txs[i].Input
+		}
+		blk := &output[owners[idx]]
+		blk.data = append(blk.data, entry)
+	}
+	return output, nil
+}
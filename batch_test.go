@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestBatchErrorObject has batches answered with a single error object. One telling the batch is too large shrinks
+// batches, a rate limit is retried at the same size.
+func TestBatchErrorObject(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	chain := newStubChain(contract, 8)
+	for _, tc := range []struct {
+		name string
+		// answer is the error object sent to the first times batches of more than limit calls
+		answer string
+		limit  int
+		times  int
+		// size is the batch size left, fails tells the calls fail for good
+		size  int
+		fails bool
+	}{
+		{name: "too large", answer: `{"code":-32600,"message":"batch too large"}`, limit: 2, times: 8, size: 2},
+		{name: "erigon limit", answer: `{"code":-32600,"message":"batch limit 2 exceeded"}`, limit: 2, times: 8, size: 2},
+		{name: "rate limited", answer: `{"code":-32005,"message":"batch rate limit exceeded"}`, limit: 0, times: 1, size: 4},
+		{name: "auth", answer: `{"code":-32002,"message":"invalid api key"}`, limit: 0, times: 2, size: 4, fails: true},
+	} {
+		stub, _ := newRPCStub(t, chain.handlers())
+		mut := sync.Mutex{}
+		times := tc.times
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, _ := io.ReadAll(r.Body)
+			var calls []json.RawMessage
+			mut.Lock()
+			reject := json.Unmarshal(raw, &calls) == nil && len(calls) > tc.limit && times > 0
+			if reject {
+				times--
+			}
+			mut.Unlock()
+			if reject {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":` + tc.answer + `}`))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(raw))
+			stub.ServeHTTP(w, r)
+		}))
+		client, err := dialRPC(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy := testPolicy()
+		policy.attempts = 2
+		b := newBatcher(client, policy, 4)
+		headers := make([]*rpcBlockHashes, 8)
+		calls := make([]rpc.BatchElem, len(headers))
+		for i := range calls {
+			calls[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{hexutil.EncodeUint64(uint64(i)), false},
+				Result: &headers[i]}
+		}
+		err = b.call(context.Background(), calls)
+		client.Close()
+		srv.Close()
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%s: batch failing every attempt succeeded", tc.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else {
+			for i, header := range headers {
+				if header == nil || uint64(header.Number) != uint64(i) {
+					t.Fatalf("%s: block %d got %v", tc.name, i, header)
+				}
+			}
+		}
+		if size := b.batchSize(); size != tc.size {
+			t.Errorf("%s: batch size %d, want %d", tc.name, size, tc.size)
+		}
+	}
+
+	for _, tc := range []struct {
+		msg  string
+		want bool
+	}{
+		{"batch too large", true},
+		{"Batch size is too large", true},
+		{"exceeded max batch size", true},
+		{"batch limit 100 exceeded", true},
+		{"batch rate limit exceeded", false},
+		{"invalid api key", false},
+		{"batch requests are not supported by this plan", false},
+	} {
+		if got := isBatchTooLarge(stubError{-32600, tc.msg}); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.msg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// firstBlockAt is the first block of [0, end) with timestamp at or after t, end when there is none. Timestamps grow
+// with block numbers, so it is a binary search.
+func firstBlockAt(ctx context.Context, client *rpc.Client, policy retryPolicy, t uint64, end uint64) (uint64, error) {
+	lo, hi := uint64(0), end
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		var info *blockInfo
+		err := policy.do(ctx, fmt.Sprintf("block %d", mid), func(ctx context.Context) error {
+			var err error
+			info, err = fetchBlockInfo(ctx, client, mid)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		if uint64(info.Timestamp) >= t {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	blst "github.com/supranational/blst/bindings/go"
+)
+
+// aggregatePubkeys is eth_aggregate_pubkeys of the altair spec
+func aggregatePubkeys(pubkeys [][48]byte) ([48]byte, error) {
+	var out [48]byte
+	if len(pubkeys) == 0 {
+		return out, errors.New("no pubkeys to aggregate")
+	}
+	points := make([]*blst.P1Affine, len(pubkeys))
+	for i := range pubkeys {
+		points[i] = new(blst.P1Affine).Uncompress(pubkeys[i][:])
+		if points[i] == nil || !points[i].KeyValidate() {
+			return out, errors.New("invalid pubkey")
+		}
+	}
+	agg := new(blst.P1Aggregate)
+	if !agg.Aggregate(points, false) {
+		return out, errors.New("failed to aggregate pubkeys")
+	}
+	copy(out[:], agg.ToAffine().Compress())
+	return out, nil
+}
+
+// dst of the proof of possession scheme, the one the consensus layer signs with
+var blsDst = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+
+// blsVerify is bls.Verify of the spec. Invalid pubkeys and signatures, infinity points included, fail verification.
+func blsVerify(pubkey [48]byte, message [32]byte, signature [96]byte) bool {
+	pk := new(blst.P1Affine).Uncompress(pubkey[:])
+	if pk == nil {
+		return false
+	}
+	sig := new(blst.P2Affine).Uncompress(signature[:])
+	if sig == nil {
+		return false
+	}
+	return sig.Verify(true, pk, true, message[:], blsDst)
+}
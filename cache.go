@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"sync"
+)
+
+// cacheFinalityDepth is how far below head a block counts as final on nodes without a finalized block, see
+// finalizedEnd. Cached blocks are trusted, they are never checked against the chain again.
+const cacheFinalityDepth = 128
+
+var cacheBlockPrefix = []byte("blk")
+
+// blockCache keeps fetchBlock results on disk, keyed by chain ID, contract and block number. Blocks without deposits
+// are stored too, with an empty value, so a repeated scan needs no RPC call at all.
+type blockCache struct {
+	db      *leveldb.Database
+	chainID uint64
+
+	// redact makes errors safe for logs, they may carry the RPC URL
+	redact func(string) string
+
+	// finalized block is looked up once, on the first block that has to be stored. Blocks from finalEnd on may
+	// still reorg and aren't stored, without it nothing is.
+	finalOnce  sync.Once
+	finalEnd   uint64
+	finalKnown bool
+}
+
+func openBlockCache(path string, chainID uint64) (*blockCache, error) {
+	db, err := leveldb.New(path, 64, 64, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache %s: %w", path, err)
+	}
+	return &blockCache{db: db, chainID: chainID, redact: func(s string) string { return s }}, nil
+}
+
+func (c *blockCache) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+func (c *blockCache) key(contract common.Address, block uint64) []byte {
+	key := make([]byte, 0, len(cacheBlockPrefix)+8+common.AddressLength+8)
+	key = append(key, cacheBlockPrefix...)
+	key = binary.BigEndian.AppendUint64(key, c.chainID)
+	key = append(key, contract.Bytes()...)
+	return binary.BigEndian.AppendUint64(key, block)
+}
+
+// fetchBlocks is f.fetchBlocks served from cache when possible, only blocks missing in cache are fetched. Nil
+// cache fetches directly.
+func (c *blockCache) fetchBlocks(ctx context.Context, f *fetcher, blocks []uint64, filter common.Address) ([]fetchBlockOutput, error) {
+	if c == nil {
+		return f.fetchBlocks(ctx, blocks, filter)
+	}
+	output := make([]fetchBlockOutput, len(blocks))
+	missing := make([]uint64, 0)
+	missingAt := make([]int, 0)
+	for i, block := range blocks {
+		var err error
+		output[i], err = c.get(filter, block)
+		// blocks cached by a run without calldata cross-check are fetched again when it is wanted
+		if err != nil || (f.calldata && !hasCalldata(output[i])) {
+			missing = append(missing, block)
+			missingAt = append(missingAt, i)
+		}
+	}
+	if len(missing) == 0 {
+		return output, nil
+	}
+	fetched, err := f.fetchBlocks(ctx, missing, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, blk := range fetched {
+		output[missingAt[i]] = blk
+		err = c.put(ctx, f, filter, blk)
+		if err != nil {
+			fmt.Printf("warning: failed to cache block %d: %v\n", blk.block, err)
+		}
+	}
+	return output, nil
+}
+
+var errNotCached = errors.New("not cached")
+
+// hasCalldata tells deposit transactions of blk come with recipient and input. Any tx emitting a deposit calls
+// something, a fetched one has at least a recipient or input.
+func hasCalldata(blk fetchBlockOutput) bool {
+	for _, txData := range blk.data {
+		if txData.to == nil && len(txData.input) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *blockCache) get(contract common.Address, block uint64) (fetchBlockOutput, error) {
+	key := c.key(contract, block)
+	// leveldb reports missing keys with an error, Has tells them apart from real failures
+	ok, err := c.db.Has(key)
+	if err != nil || !ok {
+		return fetchBlockOutput{}, errNotCached
+	}
+	raw, err := c.db.Get(key)
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	if len(raw) == 0 {
+		return fetchBlockOutput{block: block, data: make([]fetchBlockEntry, 0)}, nil
+	}
+	var stored storedBlock
+	err = json.Unmarshal(raw, &stored)
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	return stored.output(), nil
+}
+
+func (c *blockCache) put(ctx context.Context, f *fetcher, contract common.Address, output fetchBlockOutput) error {
+	c.finalOnce.Do(func() {
+		end, _, err := finalizedEnd(ctx, f.rpc, f.policy)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Printf("warning: blocks of this run won't be cached, finalized block unknown: %s\n", c.redact(err.Error()))
+			}
+			return
+		}
+		c.finalEnd, c.finalKnown = end, true
+	})
+	if !c.finalKnown || output.block >= c.finalEnd {
+		return nil
+	}
+	var raw []byte
+	if len(output.data) > 0 {
+		var err error
+		raw, err = json.Marshal(newStoredBlock(output))
+		if err != nil {
+			return err
+		}
+	}
+	return c.db.Put(c.key(contract, output.block), raw)
+}
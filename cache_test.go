@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"testing"
+)
+
+// TestBlockCache serves a repeated fetch out of cache for finalized blocks only. Nodes without a finalized block
+// have blocks cacheFinalityDepth below head cached, nothing is cached when the head can't be looked up either.
+func TestBlockCache(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	blocks := []uint64{0, 1, 2, 3}
+	for _, tc := range []struct {
+		name      string
+		finalized string
+		head      string
+		// fetched is the number of blocks the second run fetches
+		fetched int
+	}{
+		{"finalized", "0x1", "0x200", 2},
+		{"no finalized", "", "0x200", 0},
+		{"no finalized, short chain", "", "0x3", 4},
+		{"no head", "", "", 4},
+	} {
+		chain := newStubChain(contract, len(blocks))
+		handlers := chain.handlers()
+		byNumber := handlers["eth_getBlockByNumber"]
+		handlers["eth_getBlockByNumber"] = func(params []json.RawMessage) (interface{}, error) {
+			var tag string
+			if json.Unmarshal(params[0], &tag) == nil && (tag == "finalized" || tag == "latest") {
+				number := map[string]string{"finalized": tc.finalized, "latest": tc.head}[tag]
+				if number == "" {
+					return nil, errors.New(tag + " block not found")
+				}
+				return map[string]interface{}{"number": number}, nil
+			}
+			return byNumber(params)
+		}
+		stub, srv := newRPCStub(t, handlers)
+		client, err := rpc.Dial(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache, err := openBlockCache(t.TempDir(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := newFetcher(client, testPolicy(), 0)
+		f.blockReceipts = true
+		fetched := 0
+		for run := 0; run < 2; run++ {
+			before := stub.count("eth_getBlockByNumber")
+			got, err := cache.fetchBlocks(context.Background(), f, blocks, contract)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(blocks) || len(got[1].data) != 1 || got[1].data[0].hash != chain.blocks[1].receipts[0].TxHash {
+				t.Errorf("%s, run %d: got %v", tc.name, run, got)
+			}
+			fetched = stub.count("eth_getBlockByNumber") - before
+		}
+		if fetched != tc.fetched {
+			t.Errorf("%s: %d blocks fetched again, want %d", tc.name, fetched, tc.fetched)
+		}
+		_ = cache.close()
+		client.Close()
+	}
+}
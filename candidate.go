@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"math/big"
+	"time"
+)
+
+// candidateWindow is how many blocks past the scanned ones are scanned next when genesis isn't triggered yet, it
+// doubles every round
+const candidateWindow = 1024
+
+// candidateRange is [first, last] of eth1 blocks beacon nodes consider for genesis, of blocks before end. A
+// candidate is late enough for its genesis time, timestamp + GENESIS_DELAY, to reach MIN_GENESIS_TIME, and at least
+// SECONDS_PER_ETH1_BLOCK * ETH1_FOLLOW_DISTANCE seconds old at now.
+func candidateRange(ctx context.Context, client *rpc.Client, policy retryPolicy, cfg *chainConfig, end uint64, now time.Time) (uint64, uint64, error) {
+	minTimestamp := uint64(0)
+	if cfg.MinGenesisTime > cfg.GenesisDelay {
+		minTimestamp = cfg.MinGenesisTime - cfg.GenesisDelay
+	}
+	first, err := firstBlockAt(ctx, client, policy, minTimestamp, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if first == end {
+		return 0, 0, fmt.Errorf("no block at or after MIN_GENESIS_TIME - GENESIS_DELAY, timestamp %d, before block %d", minTimestamp, end)
+	}
+	followTime := cfg.SecondsPerEth1Block * cfg.Eth1FollowDistance
+	if uint64(now.Unix()) < followTime {
+		return 0, 0, fmt.Errorf("no block is %d seconds old yet", followTime)
+	}
+	// blocks before aged have timestamp + followTime <= now
+	aged, err := firstBlockAt(ctx, client, policy, uint64(now.Unix())-followTime+1, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if aged <= first {
+		return 0, 0, fmt.Errorf("block %d, the first genesis candidate, is less than %d seconds old", first, followTime)
+	}
+	return first, aged - 1, nil
+}
+
+// depositCountBlock is the first block of [first, last] by which the contract holds count deposits, ok is false
+// when it holds less at last
+func depositCountBlock(ctx context.Context, deposits *binding.Binding, policy retryPolicy, first, last, count uint64) (uint64, bool, error) {
+	if count == 0 {
+		return first, true, nil
+	}
+	held, err := depositCountAt(ctx, deposits, policy, last)
+	if err != nil || held < count {
+		return 0, false, err
+	}
+	lo, hi := first, last
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		held, err = depositCountAt(ctx, deposits, policy, mid)
+		if err != nil {
+			return 0, false, err
+		}
+		if held >= count {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, true, nil
+}
+
+// depositCountAt is the number of deposits the contract holds at block, 0 before it is deployed
+func depositCountAt(ctx context.Context, deposits *binding.Binding, policy retryPolicy, block uint64) (uint64, error) {
+	var raw []byte
+	err := policy.do(ctx, fmt.Sprintf("deposit count at block %d", block), func(ctx context.Context) error {
+		var err error
+		raw, err = deposits.GetDepositCount(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(block), Context: ctx})
+		if errors.Is(err, bind.ErrNoCode) {
+			raw, err = make([]byte, 8), nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) != 8 {
+		return 0, fmt.Errorf("invalid deposit count length %d", len(raw))
+	}
+	return binary.LittleEndian.Uint64(raw), nil
+}
+
+// triggerBlock walks candidates from first on the way beacon nodes do: the first one whose deposits, all of those
+// up to and including it, activate MIN_GENESIS_ACTIVE_VALIDATOR_COUNT validators yields a valid genesis. Activation
+// follows initializeBeaconState. ok is false when deps don't get there, active is the count reached.
+func triggerBlock(cfg *chainConfig, deps []scannedDeposit, first uint64) (block uint64, active uint64, ok bool, err error) {
+	if cfg.MinGenesisActiveValidatorCount == 0 {
+		return first, 0, true, nil
+	}
+	f, _ := cfg.genesisFork()
+	p := cfg.preset()
+	balances := make(map[[48]byte]uint64)
+	credentials := make(map[[48]byte][32]byte)
+	activated := make(map[[48]byte]bool)
+	for _, dep := range deps {
+		if !dep.data.Valid {
+			continue
+		}
+		pubkey, withdrawalCredentials, _, err := dep.data.decode()
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("deposit %d: %w", dep.index, err)
+		}
+		// credentials of the first deposit stick, later ones only top up
+		if _, ok := credentials[pubkey]; !ok {
+			credentials[pubkey] = withdrawalCredentials
+		}
+		balances[pubkey] += dep.data.Amount
+		if activated[pubkey] {
+			continue
+		}
+		if _, ok := genesisActivation(f, p, credentials[pubkey], balances[pubkey]); ok {
+			activated[pubkey] = true
+			active++
+		}
+		if active >= cfg.MinGenesisActiveValidatorCount {
+			if dep.block < first {
+				return first, active, true, nil
+			}
+			return dep.block, active, true, nil
+		}
+	}
+	return 0, active, false, nil
+}
+
+// genesisBlock finds the eth1 block genesis triggers at among candidates [first, last]. scanned are checked
+// deposits of blocks before end, blocks from end on are scanned as long as genesis isn't triggered. Returns the
+// block with deposits up to it.
+func (s *scanner) genesisBlock(ctx context.Context, cfg *chainConfig, scanned []scannedDeposit, first, last, end uint64, version forkVersion) (uint64, []scannedDeposit, error) {
+	registered := registeredPubkeys(scanned)
+	window := uint64(candidateWindow)
+	for {
+		block, active, ok, err := triggerBlock(cfg, scanned, first)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			kept := 0
+			for kept < len(scanned) && scanned[kept].block <= block {
+				kept++
+			}
+			return block, scanned[:kept], nil
+		}
+		if end > last {
+			return 0, nil, fmt.Errorf("genesis not triggered by block %d, the last candidate, %d active validators, MIN_GENESIS_ACTIVE_VALIDATOR_COUNT is %d",
+				last, active, cfg.MinGenesisActiveValidatorCount)
+		}
+		next := end + window
+		if next > last+1 {
+			next = last + 1
+		}
+		fmt.Printf("%d active validators by block %d, scanning on to block %d\n", active, end-1, next-1)
+		blockData, err := s.scan(ctx, end, next, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		found, err := extractDeposits(s.deposits, s.addr, blockData, s.opts.crossCheckCalldata)
+		if err != nil {
+			return 0, nil, err
+		}
+		err = orderDeposits(found, s.opts.firstIndex+uint64(len(scanned)))
+		if err != nil {
+			return 0, nil, err
+		}
+		markValidFollowing(found, registered, version)
+		scanned = append(scanned, found...)
+		end = next
+		window *= 2
+	}
+}
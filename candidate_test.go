@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"testing"
+)
+
+// TestDepositCountAt reads deposit count of a contract deployed in block 2 that takes a deposit every block
+func TestDepositCountAt(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	contractAbi, err := binding.BindingMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockOf := func(raw json.RawMessage) (uint64, error) {
+		var block hexutil.Uint64
+		err := json.Unmarshal(raw, &block)
+		return uint64(block), err
+	}
+	_, srv := newRPCStub(t, map[string]rpcHandler{
+		"eth_call": func(params []json.RawMessage) (interface{}, error) {
+			block, err := blockOf(params[1])
+			if err != nil || block < 2 {
+				return hexutil.Bytes{}, err
+			}
+			out, err := contractAbi.Methods["get_deposit_count"].Outputs.Pack(binary.LittleEndian.AppendUint64(nil, block-2))
+			return hexutil.Bytes(out), err
+		},
+		"eth_getCode": func(params []json.RawMessage) (interface{}, error) {
+			block, err := blockOf(params[1])
+			if err != nil || block < 2 {
+				return hexutil.Bytes{}, err
+			}
+			return hexutil.Bytes{0x60}, nil
+		},
+	})
+	client, err := rpc.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	deposits, err := binding.NewBinding(contract, ethclient.NewClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for block, want := range map[uint64]uint64{0: 0, 1: 0, 2: 0, 5: 3} {
+		count, err := depositCountAt(context.Background(), deposits, testPolicy(), block)
+		if err != nil || count != want {
+			t.Errorf("block %d: count %d, error %v, want %d", block, count, err, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// chainBlock is a block read from chain files. body decodes transactions and receipts on demand, receipts are nil
+// when the files carry none.
+type chainBlock struct {
+	header *types.Header
+	body   func() (types.Transactions, types.Receipts, error)
+}
+
+type chainReader interface {
+	// next returns the next block, io.EOF past the last one
+	next() (*chainBlock, error)
+}
+
+// exportReader reads blocks of a geth export file, RLP encoded blocks one after another. Export files hold no
+// receipts.
+type exportReader struct {
+	stream *rlp.Stream
+}
+
+func newExportReader(r io.Reader) *exportReader {
+	return &exportReader{stream: rlp.NewStream(r, 0)}
+}
+
+func (e *exportReader) next() (*chainBlock, error) {
+	var block types.Block
+	err := e.stream.Decode(&block)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		// go-ethereum of this tool doesn't know block fields added from shanghai on
+		return nil, fmt.Errorf("invalid block in geth export file: %w", err)
+	}
+	return &chainBlock{header: block.Header(), body: func() (types.Transactions, types.Receipts, error) {
+		return block.Transactions(), nil, nil
+	}}, nil
+}
+
+// listChainFiles expands a comma separated list of files and directories into files, directories contribute their
+// .era1, .rlp and .gz files in name order
+func listChainFiles(list string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		found := make([]string, 0)
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".era1" || ext == ".rlp" || ext == ".gz") {
+				found = append(found, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no .era1, .rlp or .gz files in %s", path)
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no chain files")
+	}
+	return files, nil
+}
+
+// readChainFile passes blocks of an Era1 archive or a geth export file, gzipped when named .gz, to visit
+func readChainFile(path string, visit func(*chainBlock) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = bufio.NewReader(file)
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var reader chainReader = newExportReader(r)
+	if strings.EqualFold(filepath.Ext(path), ".era1") {
+		reader = newEra1Reader(r)
+	}
+	for {
+		blk, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		err = visit(blk)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errChainFilesDone stops reading chain files past the last block wanted
+var errChainFilesDone = errors.New("chain files done")
+
+// chainFilesScan reads blocks [from, to) out of files the way fetchBlock reads them from RPC. Blocks have to
+// follow each other across files, each one the child of the one before. fromStart starts at the first block of the
+// files, toEnd reads them to the end. Header of the last block read is returned along.
+func chainFilesScan(ctx context.Context, files []string, filter common.Address, from, to uint64, fromStart, toEnd bool) ([]fetchBlockOutput, *types.Header, error) {
+	output := make([]fetchBlockOutput, 0)
+	var last *types.Header
+	var lastHash common.Hash
+	for _, path := range files {
+		fmt.Printf("reading %s\n", path)
+		err := readChainFile(path, func(blk *chainBlock) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			number := blk.header.Number.Uint64()
+			if !toEnd && number >= to {
+				return errChainFilesDone
+			}
+			if last == nil {
+				if fromStart {
+					from = number
+				}
+				if number > from {
+					return fmt.Errorf("chain files start at block %d, after block %d the scan starts at", number, from)
+				}
+			} else if number != last.Number.Uint64()+1 || blk.header.ParentHash != lastHash {
+				return fmt.Errorf("block %d of %s doesn't follow block %d %s", number, path, last.Number, lastHash.Hex())
+			}
+			last, lastHash = blk.header, blk.header.Hash()
+			if number < from || !mayHoldDeposits(blk.header.Bloom, filter) {
+				return nil
+			}
+			txs, receipts, err := blk.body()
+			if err != nil {
+				return err
+			}
+			if receipts == nil {
+				return fmt.Errorf("logs bloom of block %d tells it may hold deposits, but geth export files carry no receipts to read them from, take Era1 archives for this block", number)
+			}
+			blkOutput, err := chainFileBlock(number, lastHash, txs, receipts, filter)
+			if err != nil {
+				return err
+			}
+			if len(blkOutput.data) > 0 {
+				output = append(output, blkOutput)
+			}
+			return nil
+		})
+		if errors.Is(err, errChainFilesDone) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if last == nil {
+		return nil, nil, errors.New("no blocks in chain files")
+	}
+	if !toEnd && last.Number.Uint64()+1 < to {
+		return nil, nil, fmt.Errorf("chain files end at block %d, before block %d the scan ends at", last.Number, to-1)
+	}
+	if last.Number.Uint64() < from {
+		return nil, nil, fmt.Errorf("chain files end at block %d, before block %d the scan starts at", last.Number, from)
+	}
+	return output, last, nil
+}
+
+// chainFileBlock picks transactions with successful receipts holding logs of filter, as fetchBlock does
+func chainFileBlock(number uint64, hash common.Hash, txs types.Transactions, receipts types.Receipts, filter common.Address) (fetchBlockOutput, error) {
+	output := fetchBlockOutput{block: number, data: make([]fetchBlockEntry, 0)}
+	if len(txs) != len(receipts) {
+		return output, fmt.Errorf("block %d holds %d transactions, but %d receipts", number, len(txs), len(receipts))
+	}
+	// receipts as stored leave out log positions, extraction orders logs by them
+	logIndex := uint(0)
+	for i, rcpt := range receipts {
+		txHash := txs[i].Hash()
+		for _, l := range rcpt.Logs {
+			l.BlockNumber, l.BlockHash, l.TxHash, l.TxIndex, l.Index = number, hash, txHash, uint(i), logIndex
+			logIndex++
+		}
+		if rcpt.Status != types.ReceiptStatusSuccessful || !hasLogFrom(rcpt.Logs, filter) {
+			continue
+		}
+		output.data = append(output.data, fetchBlockEntry{
+			hash:  txHash,
+			to:    txs[i].To(),
+			input: txs[i].Data(),
+			logs:  rcpt.Logs,
+		})
+	}
+	return output, nil
+}
+
+// runOffline is run with blocks read from chain files instead of RPC. Files carry no contract state, so the
+// deposit root is printed rather than compared with the contract.
+func runOffline(ctx context.Context, opts *options, cfg *chainConfig) error {
+	files, err := listChainFiles(opts.chainFiles)
+	if err != nil {
+		return err
+	}
+	addr := common.HexToAddress(opts.contract)
+	// events are only parsed, the binding never calls out
+	deposits, err := binding.NewBinding(addr, nil)
+	if err != nil {
+		return err
+	}
+	blockData, last, err := chainFilesScan(ctx, files, addr, opts.fromBlock, opts.toBlock, opts.discoverFrom, opts.latest)
+	if ctx.Err() != nil {
+		return errors.New("scan interrupted")
+	}
+	if err != nil {
+		return err
+	}
+	scanned, err := extractDeposits(deposits, addr, blockData, opts.crossCheckCalldata)
+	if err != nil {
+		return err
+	}
+	err = orderDeposits(scanned, opts.firstIndex)
+	if err != nil {
+		return err
+	}
+	if opts.firstIndex == 0 {
+		root, err := depositRoot(scanned)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deposit root at block %d is %s with %d deposits, chain files hold no contract state to check it against\n",
+			last.Number, hexutil.Encode(root[:]), len(scanned))
+	}
+	version, err := opts.depositForkVersion(cfg)
+	if err != nil {
+		return err
+	}
+	invalid := markValidDeposits(scanned, version)
+	if invalid > 0 {
+		fmt.Printf("%d deposits with invalid signature\n", invalid)
+	}
+	written, err := writeDepositData(opts.output, scanned, opts.excludeInvalid)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scan done!\n Deposit data written OK\n Found %d deposits\n", written)
+	if opts.genesis {
+		info := &blockInfo{Number: hexutil.Uint64(last.Number.Uint64()), Hash: last.Hash(), Timestamp: hexutil.Uint64(last.Time)}
+		return writeGenesisState(cfg, info, scanned, opts.executionHeader, opts.genesisOutput)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeExportFile writes blocks the way geth export does, one RLP encoded block after another
+func writeExportFile(t *testing.T, path string, blocks []testChainBlock) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	for _, blk := range blocks {
+		err := rlp.Encode(buf, blk.block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(path, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestChainFilesScan reads an Era1 archive of blocks 0 and 1 followed by export files, which may only hold blocks
+// without deposits
+func TestChainFilesScan(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	dir := t.TempDir()
+	archived := newTestChain(nil, 0, 2, contract)
+	era1Path := filepath.Join(dir, "chain-00000.era1")
+	err := os.WriteFile(era1Path, encodeEra1(t, archived), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocks 2 and 3 follow the archive, block 3 holds a deposit
+	exported := newTestChain(archived[1].block.Header(), 2, 2, contract)
+	exportPath := filepath.Join(dir, "chain-00001.rlp")
+	writeExportFile(t, exportPath, exported[:1])
+	depositExportPath := filepath.Join(dir, "deposit.rlp")
+	writeExportFile(t, depositExportPath, exported)
+	// block 2 of another chain, its parent isn't block 1 of the archive
+	forkedPath := filepath.Join(dir, "forked.rlp")
+	writeExportFile(t, forkedPath, newTestChain(exported[0].block.Header(), 2, 1, contract))
+
+	files, err := listChainFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[0] != era1Path || files[1] != exportPath {
+		t.Fatalf("listed %v", files)
+	}
+
+	output, last, err := chainFilesScan(context.Background(), []string{era1Path, exportPath}, contract, 0, 0, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Hash() != exported[0].block.Hash() {
+		t.Fatalf("last block %d %s, want 2 %s", last.Number, last.Hash().Hex(), exported[0].block.Hash().Hex())
+	}
+	if len(output) != 1 || output[0].block != 1 || len(output[0].data) != 1 {
+		t.Fatalf("got %d blocks with deposits, want block 1", len(output))
+	}
+	entry := output[0].data[0]
+	if entry.hash != archived[1].block.Transactions()[0].Hash() || len(entry.logs) != 1 || entry.logs[0].BlockHash != archived[1].block.Hash() {
+		t.Fatalf("got tx %s with logs %v", entry.hash.Hex(), entry.logs)
+	}
+
+	// ranges ending within the archive don't read export files at all
+	output, last, err = chainFilesScan(context.Background(), []string{era1Path, depositExportPath}, contract, 1, 2, false, false)
+	if err != nil || len(output) != 1 || last.Number.Uint64() != 1 {
+		t.Fatalf("scan of block 1 got %d blocks, last %v, error %v", len(output), last, err)
+	}
+
+	for _, tc := range []struct {
+		files []string
+		want  string
+	}{
+		{[]string{era1Path, forkedPath}, "doesn't follow block 1"},
+		{[]string{era1Path, depositExportPath}, "logs bloom of block 3 tells it may hold deposits"},
+		{[]string{exportPath}, "chain files start at block 2, after block 0"},
+		{[]string{era1Path}, "chain files end at block 1, before block 3"},
+	} {
+		_, _, err = chainFilesScan(context.Background(), tc.files, contract, 0, 4, false, false)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got %v, want %s", tc.files, err, tc.want)
+		}
+	}
+}
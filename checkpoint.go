@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"os"
+	"time"
+)
+
+// checkpointInterval is how often scan progress goes to disk
+const checkpointInterval = 30 * time.Second
+
+// scanCheckpoint is scan progress as stored on disk. Every block in [From, Next) is done, Blocks holds the ones
+// with deposits in them. Chains may share the deposit contract address, ChainID tells them apart.
+type scanCheckpoint struct {
+	ChainID  uint64         `json:"chain_id"`
+	Contract common.Address `json:"contract"`
+	From     uint64         `json:"from"`
+	Next     uint64         `json:"next"`
+	Blocks   []storedBlock  `json:"blocks"`
+}
+
+// storedBlock is fetchBlockOutput in a form that survives a round trip through JSON
+type storedBlock struct {
+	Number uint64     `json:"number"`
+	Txs    []storedTx `json:"txs"`
+}
+
+type storedTx struct {
+	Hash  common.Hash     `json:"hash"`
+	To    *common.Address `json:"to"`
+	Input hexutil.Bytes   `json:"input"`
+	Logs  []*types.Log    `json:"logs"`
+}
+
+func newStoredBlock(blk fetchBlockOutput) storedBlock {
+	stored := storedBlock{Number: blk.block, Txs: make([]storedTx, len(blk.data))}
+	for i, txData := range blk.data {
+		stored.Txs[i] = storedTx{Hash: txData.hash, To: txData.to, Input: txData.input, Logs: txData.logs}
+	}
+	return stored
+}
+
+func (b *storedBlock) output() fetchBlockOutput {
+	output := fetchBlockOutput{block: b.Number, data: make([]fetchBlockEntry, len(b.Txs))}
+	for i, txData := range b.Txs {
+		output.data[i] = fetchBlockEntry{hash: txData.Hash, to: txData.To, input: txData.Input, logs: txData.Logs}
+	}
+	return output
+}
+
+// checkpointer collects finished blocks of a scan and writes them out every checkpointInterval. Blocks have to be
+// reported in order, with no gaps.
+type checkpointer struct {
+	path      string
+	state     scanCheckpoint
+	lastWrite time.Time
+}
+
+func newCheckpointer(path string, chainID uint64, contract common.Address, from uint64) *checkpointer {
+	return &checkpointer{
+		path:      path,
+		state:     scanCheckpoint{ChainID: chainID, Contract: contract, From: from, Next: from, Blocks: make([]storedBlock, 0)},
+		lastWrite: time.Now(),
+	}
+}
+
+// loadCheckpointer continues from the checkpoint at path, which has to be of a scan of the same contract on the
+// same chain that started at the same block
+func loadCheckpointer(path string, chainID uint64, contract common.Address, from uint64) (*checkpointer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpointer{path: path, lastWrite: time.Now()}
+	err = json.Unmarshal(raw, &c.state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if c.state.ChainID != chainID || c.state.Contract != contract || c.state.From != from {
+		return nil, fmt.Errorf("checkpoint %s is of a scan of %s on chain %d from block %d, not %s on chain %d from block %d",
+			path, c.state.Contract.Hex(), c.state.ChainID, c.state.From, contract.Hex(), chainID, from)
+	}
+	return c, nil
+}
+
+// next is the first block not scanned yet
+func (c *checkpointer) next() uint64 {
+	return c.state.Next
+}
+
+// blocks returns what was scanned so far in the layout fetch functions produce
+func (c *checkpointer) blocks() []fetchBlockOutput {
+	output := make([]fetchBlockOutput, len(c.state.Blocks))
+	for i := range c.state.Blocks {
+		output[i] = c.state.Blocks[i].output()
+	}
+	return output
+}
+
+// progress records blocks up to next as done and writes the checkpoint when it is due. Failed write doesn't stop
+// the scan, the next one may go through.
+func (c *checkpointer) progress(next uint64, blocks []fetchBlockOutput) {
+	for _, blk := range blocks {
+		if len(blk.data) == 0 {
+			continue
+		}
+		c.state.Blocks = append(c.state.Blocks, newStoredBlock(blk))
+	}
+	c.state.Next = next
+	if time.Since(c.lastWrite) < checkpointInterval {
+		return
+	}
+	err := c.flush()
+	if err != nil {
+		fmt.Printf("warning: failed to write checkpoint: %v\n", err)
+	}
+}
+
+// flush writes the checkpoint, through a temporary file so an interrupted write keeps the previous one
+func (c *checkpointer) flush() error {
+	raw, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	err = os.WriteFile(tmp, raw, 0600)
+	if err != nil {
+		return err
+	}
+	c.lastWrite = time.Now()
+	return os.Rename(tmp, c.path)
+}
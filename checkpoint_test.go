@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadCheckpointer resumes a checkpoint of the same scan and refuses ones of another chain, contract or start
+// block
+func TestLoadCheckpointer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.checkpoint.json")
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	cp := newCheckpointer(path, 1, contract, 100)
+	cp.progress(120, []fetchBlockOutput{{block: 110, data: []fetchBlockEntry{{hash: common.Hash{0x01}}}}})
+	err := cp.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCheckpointer(path, 1, contract, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.next() != 120 || len(loaded.blocks()) != 1 || loaded.blocks()[0].block != 110 {
+		t.Errorf("got next %d, blocks %v", loaded.next(), loaded.blocks())
+	}
+	for _, tc := range []struct {
+		chainID  uint64
+		contract common.Address
+		from     uint64
+	}{
+		// hoodi has the deposit contract at the mainnet address
+		{560048, contract, 100},
+		{1, common.HexToAddress("0x4242424242424242424242424242424242424242"), 100},
+		{1, contract, 0},
+	} {
+		_, err = loadCheckpointer(path, tc.chainID, tc.contract, tc.from)
+		if err == nil {
+			t.Errorf("checkpoint taken for chain %d, contract %s from %d", tc.chainID, tc.contract.Hex(), tc.from)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// chainConfig holds consensus layer parameters, read from config.yaml in the format used by testnet directories.
+// Keys not needed by this tool are ignored.
+type chainConfig struct {
+	PresetBase                     string      `yaml:"PRESET_BASE"`
+	MinGenesisActiveValidatorCount uint64      `yaml:"MIN_GENESIS_ACTIVE_VALIDATOR_COUNT"`
+	MinGenesisTime                 uint64      `yaml:"MIN_GENESIS_TIME"`
+	GenesisForkVersion             forkVersion `yaml:"GENESIS_FORK_VERSION"`
+	GenesisDelay                   uint64      `yaml:"GENESIS_DELAY"`
+	SecondsPerEth1Block            uint64      `yaml:"SECONDS_PER_ETH1_BLOCK"`
+	Eth1FollowDistance             uint64      `yaml:"ETH1_FOLLOW_DISTANCE"`
+	DepositChainID                 uint64      `yaml:"DEPOSIT_CHAIN_ID"`
+	DepositContractAddress         string      `yaml:"DEPOSIT_CONTRACT_ADDRESS"`
+	AltairForkVersion              forkVersion `yaml:"ALTAIR_FORK_VERSION"`
+	AltairForkEpoch                uint64      `yaml:"ALTAIR_FORK_EPOCH"`
+	BellatrixForkVersion           forkVersion `yaml:"BELLATRIX_FORK_VERSION"`
+	BellatrixForkEpoch             uint64      `yaml:"BELLATRIX_FORK_EPOCH"`
+	CapellaForkVersion             forkVersion `yaml:"CAPELLA_FORK_VERSION"`
+	CapellaForkEpoch               uint64      `yaml:"CAPELLA_FORK_EPOCH"`
+	DenebForkVersion               forkVersion `yaml:"DENEB_FORK_VERSION"`
+	DenebForkEpoch                 uint64      `yaml:"DENEB_FORK_EPOCH"`
+	ElectraForkVersion             forkVersion `yaml:"ELECTRA_FORK_VERSION"`
+	ElectraForkEpoch               uint64      `yaml:"ELECTRA_FORK_EPOCH"`
+}
+
+type fork int
+
+const (
+	forkPhase0 fork = iota
+	forkAltair
+	forkBellatrix
+	forkCapella
+	forkDeneb
+	forkElectra
+)
+
+var forkNames = [...]string{"phase0", "altair", "bellatrix", "capella", "deneb", "electra"}
+
+func (f fork) String() string {
+	return forkNames[f]
+}
+
+type forkVersion [4]byte
+
+// UnmarshalYAML reads the version from its hex form. yaml would otherwise take 0x00000000 for an integer.
+func (v *forkVersion) UnmarshalYAML(node *yaml.Node) error {
+	raw, err := hexutil.Decode(node.Value)
+	if err != nil {
+		return fmt.Errorf("invalid fork version %q: %w", node.Value, err)
+	}
+	if len(raw) != len(v) {
+		return fmt.Errorf("invalid fork version %q: want %d bytes", node.Value, len(v))
+	}
+	copy(v[:], raw)
+	return nil
+}
+
+func loadChainConfig(path string) (*chainConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// forks missing from config never happen
+	cfg := &chainConfig{
+		PresetBase:          "mainnet",
+		SecondsPerEth1Block: 14,
+		Eth1FollowDistance:  2048,
+		AltairForkEpoch:     farFutureEpoch,
+		BellatrixForkEpoch:  farFutureEpoch,
+		CapellaForkEpoch:    farFutureEpoch,
+		DenebForkEpoch:      farFutureEpoch,
+		ElectraForkEpoch:    farFutureEpoch,
+	}
+	err = yaml.Unmarshal(raw, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if _, ok := presets[cfg.PresetBase]; !ok {
+		return nil, fmt.Errorf("unknown preset %q", cfg.PresetBase)
+	}
+	epochs := cfg.forkEpochs()
+	for f := forkAltair; f <= forkElectra; f++ {
+		if epochs[f] < epochs[f-1] {
+			return nil, fmt.Errorf("%s fork epoch %d is before %s fork epoch %d", f, epochs[f], f-1, epochs[f-1])
+		}
+	}
+	return cfg, nil
+}
+
+func (c *chainConfig) forkEpochs() [len(forkNames)]uint64 {
+	return [...]uint64{genesisEpoch, c.AltairForkEpoch, c.BellatrixForkEpoch, c.CapellaForkEpoch, c.DenebForkEpoch, c.ElectraForkEpoch}
+}
+
+// genesisFork is the latest fork scheduled at genesis epoch, genesis state is built with its containers and version
+func (c *chainConfig) genesisFork() (fork, forkVersion) {
+	versions := [...]forkVersion{c.GenesisForkVersion, c.AltairForkVersion, c.BellatrixForkVersion, c.CapellaForkVersion, c.DenebForkVersion, c.ElectraForkVersion}
+	epochs := c.forkEpochs()
+	f := forkPhase0
+	for next := forkAltair; next <= forkElectra && epochs[next] == genesisEpoch; next++ {
+		f = next
+	}
+	return f, versions[f]
+}
+
+func (c *chainConfig) preset() preset {
+	return presets[c.PresetBase]
+}
+
+// preset holds compile time constants of consensus clients, the ones that shape containers
+type preset struct {
+	slotsPerEpoch             uint64
+	slotsPerHistoricalRoot    uint64
+	epochsPerHistoricalVector uint64
+	epochsPerSlashingsVector  uint64
+	historicalRootsLimit      uint64
+	validatorRegistryLimit    uint64
+	epochsPerEth1VotingPeriod uint64
+	maxProposerSlashings      uint64
+	maxAttesterSlashings      uint64
+	maxAttestations           uint64
+	maxDeposits               uint64
+	maxVoluntaryExits         uint64
+	maxEffectiveBalance       uint64
+	effectiveBalanceIncrement uint64
+	shuffleRoundCount         uint64
+	// altair
+	syncCommitteeSize uint64
+	// bellatrix
+	maxBytesPerTransaction    uint64
+	maxTransactionsPerPayload uint64
+	bytesPerLogsBloom         uint64
+	maxExtraDataBytes         uint64
+	// capella
+	maxBlsToExecutionChanges uint64
+	maxWithdrawalsPerPayload uint64
+	historicalSummariesLimit uint64
+	// deneb
+	maxBlobCommitmentsPerBlock uint64
+	// electra
+	minActivationBalance               uint64
+	maxEffectiveBalanceElectra         uint64
+	pendingDepositsLimit               uint64
+	pendingPartialWithdrawalsLimit     uint64
+	pendingConsolidationsLimit         uint64
+	maxAttesterSlashingsElectra        uint64
+	maxAttestationsElectra             uint64
+	maxDepositRequestsPerPayload       uint64
+	maxWithdrawalRequestsPerPayload    uint64
+	maxConsolidationRequestsPerPayload uint64
+}
+
+var presets = map[string]preset{
+	"mainnet": {
+		slotsPerEpoch:             32,
+		slotsPerHistoricalRoot:    8192,
+		epochsPerHistoricalVector: 65536,
+		epochsPerSlashingsVector:  8192,
+		historicalRootsLimit:      16777216,
+		validatorRegistryLimit:    1 << 40,
+		epochsPerEth1VotingPeriod: 64,
+		maxProposerSlashings:      16,
+		maxAttesterSlashings:      2,
+		maxAttestations:           128,
+		maxDeposits:               16,
+		maxVoluntaryExits:         16,
+		maxEffectiveBalance:       32_000_000_000,
+		effectiveBalanceIncrement: 1_000_000_000,
+		shuffleRoundCount:         90,
+
+		syncCommitteeSize: 512,
+
+		maxBytesPerTransaction:    1 << 30,
+		maxTransactionsPerPayload: 1 << 20,
+		bytesPerLogsBloom:         256,
+		maxExtraDataBytes:         32,
+
+		maxBlsToExecutionChanges: 16,
+		maxWithdrawalsPerPayload: 16,
+		historicalSummariesLimit: 16777216,
+
+		maxBlobCommitmentsPerBlock: 4096,
+
+		minActivationBalance:               32_000_000_000,
+		maxEffectiveBalanceElectra:         2048_000_000_000,
+		pendingDepositsLimit:               1 << 27,
+		pendingPartialWithdrawalsLimit:     1 << 27,
+		pendingConsolidationsLimit:         1 << 18,
+		maxAttesterSlashingsElectra:        1,
+		maxAttestationsElectra:             8,
+		maxDepositRequestsPerPayload:       8192,
+		maxWithdrawalRequestsPerPayload:    16,
+		maxConsolidationRequestsPerPayload: 2,
+	},
+	"minimal": {
+		slotsPerEpoch:             8,
+		slotsPerHistoricalRoot:    64,
+		epochsPerHistoricalVector: 64,
+		epochsPerSlashingsVector:  64,
+		historicalRootsLimit:      16777216,
+		validatorRegistryLimit:    1 << 40,
+		epochsPerEth1VotingPeriod: 4,
+		maxProposerSlashings:      16,
+		maxAttesterSlashings:      2,
+		maxAttestations:           128,
+		maxDeposits:               16,
+		maxVoluntaryExits:         16,
+		maxEffectiveBalance:       32_000_000_000,
+		effectiveBalanceIncrement: 1_000_000_000,
+		shuffleRoundCount:         10,
+
+		syncCommitteeSize: 32,
+
+		maxBytesPerTransaction:    1 << 30,
+		maxTransactionsPerPayload: 1 << 20,
+		bytesPerLogsBloom:         256,
+		maxExtraDataBytes:         32,
+
+		maxBlsToExecutionChanges: 16,
+		maxWithdrawalsPerPayload: 4,
+		historicalSummariesLimit: 16777216,
+
+		maxBlobCommitmentsPerBlock: 32,
+
+		minActivationBalance:               32_000_000_000,
+		maxEffectiveBalanceElectra:         2048_000_000_000,
+		pendingDepositsLimit:               1 << 27,
+		pendingPartialWithdrawalsLimit:     64,
+		pendingConsolidationsLimit:         64,
+		maxAttesterSlashingsElectra:        1,
+		maxAttestationsElectra:             8,
+		maxDepositRequestsPerPayload:       4,
+		maxWithdrawalRequestsPerPayload:    2,
+		maxConsolidationRequestsPerPayload: 2,
+	},
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"math/big"
+)
+
+// depositContractInterfaceID is type(IDepositContract).interfaceId of contract.sol, selectors of deposit,
+// get_deposit_root and get_deposit_count xored
+var depositContractInterfaceID = [4]byte{0x85, 0x64, 0x09, 0x07}
+
+// findDeployBlock finds the block the deposit contract was deployed in, the first one before end with code at
+// contract. State of old blocks is needed, so is an archive node.
+func findDeployBlock(ctx context.Context, eth *ethclient.Client, deposits *binding.Binding, policy retryPolicy, contract common.Address, end uint64) (uint64, error) {
+	hasCode := func(block uint64) (bool, error) {
+		var code []byte
+		err := policy.do(ctx, fmt.Sprintf("code of %s at block %d", contract.Hex(), block), func(ctx context.Context) error {
+			var err error
+			code, err = eth.CodeAt(ctx, contract, new(big.Int).SetUint64(block))
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("%w, finding deployment block needs an archive node, set --from-block", err)
+		}
+		return len(code) > 0, nil
+	}
+	if end == 0 {
+		return 0, errors.New("no blocks to find the deposit contract in")
+	}
+	last := end - 1
+	ok, err := hasCode(last)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no contract at %s in block %d", contract.Hex(), last)
+	}
+	var supported bool
+	err = policy.do(ctx, fmt.Sprintf("interface of %s at block %d", contract.Hex(), last), func(ctx context.Context) error {
+		var err error
+		supported, err = deposits.SupportsInterface(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(last), Context: ctx}, depositContractInterfaceID)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to check interface of %s: %w", contract.Hex(), err)
+	}
+	if !supported {
+		return 0, fmt.Errorf("contract at %s is not a deposit contract", contract.Hex())
+	}
+
+	// code at hi, none below lo
+	lo, hi := uint64(0), last
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		ok, err = hasCode(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+	"io"
+)
+
+// e2store entry types of Era1 archives. A file is a version entry, then header, body, receipts and total
+// difficulty of each block, then the accumulator and the block index.
+const (
+	e2Version              = 0x3265
+	era1CompressedHeader   = 0x03
+	era1CompressedBody     = 0x04
+	era1CompressedReceipts = 0x05
+	era1TotalDifficulty    = 0x06
+	era1Accumulator        = 0x07
+	era1BlockIndex         = 0x3266
+)
+
+// era1Reader reads blocks of an Era1 archive in order. Entries are snappy framed RLP, bodies and receipts are
+// decompressed only when asked for.
+type era1Reader struct {
+	r *bufio.Reader
+	// started is set once the version entry was read
+	started bool
+	done    bool
+}
+
+func newEra1Reader(r io.Reader) *era1Reader {
+	return &era1Reader{r: bufio.NewReader(r)}
+}
+
+// entry reads the next e2store entry: type, length and reserved field, all little endian, then length bytes of data
+func (e *era1Reader) entry() (uint16, []byte, error) {
+	var header [8]byte
+	_, err := io.ReadFull(e.r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := binary.LittleEndian.Uint16(header[0:2])
+	length := binary.LittleEndian.Uint32(header[2:6])
+	if binary.LittleEndian.Uint16(header[6:8]) != 0 {
+		return 0, nil, fmt.Errorf("invalid e2store entry of type %#x, reserved field is not zero", typ)
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(e.r, data)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return typ, data, err
+}
+
+// next returns the next block, io.EOF past the last one
+func (e *era1Reader) next() (*chainBlock, error) {
+	if e.done {
+		return nil, io.EOF
+	}
+	if !e.started {
+		typ, _, err := e.entry()
+		if errors.Is(err, io.EOF) || (err == nil && typ != e2Version) {
+			return nil, errors.New("not an Era1 archive, no version entry")
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.started = true
+	}
+	var header, body, receipts []byte
+	for {
+		typ, data, err := e.entry()
+		if errors.Is(err, io.EOF) {
+			if header != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			e.done = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case era1CompressedHeader:
+			header = data
+		case era1CompressedBody:
+			body = data
+		case era1CompressedReceipts:
+			receipts = data
+		case era1TotalDifficulty:
+			// closes a block
+			if header == nil || body == nil || receipts == nil {
+				return nil, errors.New("Era1 block without header, body or receipts")
+			}
+			return era1Block(header, body, receipts)
+		case era1Accumulator, era1BlockIndex:
+			if header != nil {
+				return nil, errors.New("Era1 block without total difficulty")
+			}
+			e.done = true
+			return nil, io.EOF
+		}
+	}
+}
+
+func era1Block(header, body, receipts []byte) (*chainBlock, error) {
+	blk := &chainBlock{header: new(types.Header)}
+	err := decodeSnappyRLP(header, blk.header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Era1 header: %w", err)
+	}
+	blk.body = func() (types.Transactions, types.Receipts, error) {
+		var decodedBody types.Body
+		err := decodeSnappyRLP(body, &decodedBody)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid Era1 body of block %d: %w", blk.header.Number, err)
+		}
+		decodedReceipts := make(types.Receipts, 0)
+		err = decodeSnappyRLP(receipts, &decodedReceipts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid Era1 receipts of block %d: %w", blk.header.Number, err)
+		}
+		return decodedBody.Transactions, decodedReceipts, nil
+	}
+	return blk, nil
+}
+
+func decodeSnappyRLP(data []byte, out interface{}) error {
+	raw, err := io.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	return rlp.DecodeBytes(raw, out)
+}
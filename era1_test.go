@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+	"io"
+	"math/big"
+	"testing"
+)
+
+// testChainBlock is a block of a chain built for chain file tests, receipts included
+type testChainBlock struct {
+	block    *types.Block
+	receipts types.Receipts
+}
+
+// newTestChain builds blocks following parent, odd ones hold a deposit transaction of contract, even ones are empty
+func newTestChain(parent *types.Header, first uint64, count int, contract common.Address) []testChainBlock {
+	blocks := make([]testChainBlock, 0, count)
+	parentHash := common.Hash{}
+	if parent != nil {
+		parentHash = parent.Hash()
+	}
+	for number := first; number < first+uint64(count); number++ {
+		header := &types.Header{ParentHash: parentHash, Number: new(big.Int).SetUint64(number), Difficulty: big.NewInt(1),
+			GasLimit: 30000000, Time: 1600000000 + number*12}
+		txs := types.Transactions{}
+		receipts := types.Receipts{}
+		if number%2 == 1 {
+			txs = append(txs, types.NewTx(&types.LegacyTx{Nonce: number, To: &contract, Gas: 100000,
+				GasPrice: big.NewInt(1), Data: []byte{0x22, 0x89, 0x51, 0x18}}))
+			receipts = append(receipts, &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 50000,
+				Logs: []*types.Log{{Address: contract, Topics: []common.Hash{depositEventTopic}, Data: []byte{0x01}}}})
+			header.Bloom = types.CreateBloom(receipts)
+		}
+		block := types.NewBlockWithHeader(header).WithBody(txs, nil)
+		blocks = append(blocks, testChainBlock{block: block, receipts: receipts})
+		parentHash = block.Hash()
+	}
+	return blocks
+}
+
+// encodeEra1 lays out blocks as an Era1 archive, accumulator and block index carry no meaning for the reader
+func encodeEra1(t *testing.T, blocks []testChainBlock) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	entry := func(typ uint16, data []byte) {
+		var header [8]byte
+		binary.LittleEndian.PutUint16(header[0:2], typ)
+		binary.LittleEndian.PutUint32(header[2:6], uint32(len(data)))
+		buf.Write(header[:])
+		buf.Write(data)
+	}
+	compressed := func(val interface{}) []byte {
+		raw, err := rlp.EncodeToBytes(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		w := snappy.NewBufferedWriter(out)
+		_, err = w.Write(raw)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	entry(e2Version, nil)
+	for _, blk := range blocks {
+		entry(era1CompressedHeader, compressed(blk.block.Header()))
+		entry(era1CompressedBody, compressed(blk.block.Body()))
+		entry(era1CompressedReceipts, compressed(blk.receipts))
+		entry(era1TotalDifficulty, make([]byte, 32))
+	}
+	entry(era1Accumulator, make([]byte, 32))
+	entry(era1BlockIndex, make([]byte, 8+8*len(blocks)+8))
+	return buf.Bytes()
+}
+
+func TestEra1Reader(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	blocks := newTestChain(nil, 0, 2, contract)
+	archive := encodeEra1(t, blocks)
+
+	reader := newEra1Reader(bytes.NewReader(archive))
+	for i, want := range blocks {
+		blk, err := reader.next()
+		if err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if blk.header.Hash() != want.block.Hash() {
+			t.Fatalf("block %d: hash %s, want %s", i, blk.header.Hash().Hex(), want.block.Hash().Hex())
+		}
+		txs, receipts, err := blk.body()
+		if err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if len(txs) != len(want.receipts) || len(receipts) != len(want.receipts) {
+			t.Fatalf("block %d: %d transactions and %d receipts, want %d", i, len(txs), len(receipts), len(want.receipts))
+		}
+		for j, tx := range txs {
+			if tx.Hash() != want.block.Transactions()[j].Hash() || len(receipts[j].Logs) != 1 || receipts[j].Logs[0].Address != contract {
+				t.Errorf("block %d: transaction %d is %s with logs %v", i, j, tx.Hash().Hex(), receipts[j].Logs)
+			}
+		}
+	}
+	if _, err := reader.next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("past last block got %v, want EOF", err)
+	}
+
+	// archive cut in the receipts of the second block, before total difficulty, accumulator and block index
+	cut := len(archive) - (8 + 32) - (8 + 32) - (8 + 8 + 8*len(blocks) + 8) - 1
+	reader = newEra1Reader(bytes.NewReader(archive[:cut]))
+	_, err := reader.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.next(); err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("truncated archive got %v", err)
+	}
+
+	if _, err = newEra1Reader(bytes.NewReader(nil)).next(); err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("empty archive got %v", err)
+	}
+}
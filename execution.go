@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"os"
+)
+
+// executionHeader is an execution layer block in the eth_getBlockByNumber format, the source of
+// latest_execution_payload_header from bellatrix on. Usually it is the genesis block of the execution chain.
+type executionHeader struct {
+	ParentHash    common.Hash       `json:"parentHash"`
+	FeeRecipient  common.Address    `json:"miner"`
+	StateRoot     common.Hash       `json:"stateRoot"`
+	ReceiptsRoot  common.Hash       `json:"receiptsRoot"`
+	LogsBloom     hexutil.Bytes     `json:"logsBloom"`
+	PrevRandao    common.Hash       `json:"mixHash"`
+	Number        hexutil.Uint64    `json:"number"`
+	GasLimit      hexutil.Uint64    `json:"gasLimit"`
+	GasUsed       hexutil.Uint64    `json:"gasUsed"`
+	Timestamp     hexutil.Uint64    `json:"timestamp"`
+	ExtraData     hexutil.Bytes     `json:"extraData"`
+	BaseFeePerGas *hexutil.Big      `json:"baseFeePerGas"`
+	BlockHash     common.Hash       `json:"hash"`
+	BlobGasUsed   hexutil.Uint64    `json:"blobGasUsed"`
+	ExcessBlobGas hexutil.Uint64    `json:"excessBlobGas"`
+	Transactions  []json.RawMessage `json:"transactions"`
+	Withdrawals   []json.RawMessage `json:"withdrawals"`
+}
+
+// loadExecutionHeader reads the block from path, taking its hash as is. The payload header only carries roots of
+// transactions and withdrawals, both have to be empty to be derived without the bodies.
+func loadExecutionHeader(path string) (*executionHeader, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header := &executionHeader{}
+	err = json.Unmarshal(raw, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(header.Transactions) != 0 {
+		return nil, fmt.Errorf("execution block %d has %d transactions, only empty blocks are supported", header.Number, len(header.Transactions))
+	}
+	if len(header.Withdrawals) != 0 {
+		return nil, fmt.Errorf("execution block %d has %d withdrawals, only empty blocks are supported", header.Number, len(header.Withdrawals))
+	}
+	if len(header.LogsBloom) != 0 && len(header.LogsBloom) != 256 {
+		return nil, fmt.Errorf("invalid logs bloom length %d", len(header.LogsBloom))
+	}
+	if len(header.ExtraData) > 32 {
+		return nil, fmt.Errorf("extra data of %d bytes is over 32 bytes limit", len(header.ExtraData))
+	}
+	if header.BaseFeePerGas != nil && (header.BaseFeePerGas.ToInt().Sign() < 0 || header.BaseFeePerGas.ToInt().BitLen() > 256) {
+		return nil, fmt.Errorf("base fee %s doesn't fit uint256", header.BaseFeePerGas)
+	}
+	return header, nil
+}
+
+// ssz encodes ExecutionPayloadHeader of fork f. Zero value is the default header of a chain that isn't merged yet.
+// Since transactions and withdrawals are empty, the root is also the one of an ExecutionPayload with same fields.
+func (h *executionHeader) ssz(f fork, p preset) sszValue {
+	logsBloom := make([]byte, p.bytesPerLogsBloom)
+	copy(logsBloom, h.LogsBloom)
+	// uint256 is little endian
+	var baseFee [32]byte
+	if h.BaseFeePerGas != nil {
+		be := h.BaseFeePerGas.ToInt().Bytes()
+		for i, b := range be {
+			baseFee[len(be)-1-i] = b
+		}
+	}
+	transactionsRoot := sszList(nil, p.maxTransactionsPerPayload).root
+
+	fields := []sszValue{
+		sszByteVector(h.ParentHash[:]),
+		sszByteVector(h.FeeRecipient[:]),
+		sszByteVector(h.StateRoot[:]),
+		sszByteVector(h.ReceiptsRoot[:]),
+		sszByteVector(logsBloom),
+		sszByteVector(h.PrevRandao[:]),
+		sszUint64(uint64(h.Number)),
+		sszUint64(uint64(h.GasLimit)),
+		sszUint64(uint64(h.GasUsed)),
+		sszUint64(uint64(h.Timestamp)),
+		sszByteList(h.ExtraData, p.maxExtraDataBytes),
+		sszByteVector(baseFee[:]),
+		sszByteVector(h.BlockHash[:]),
+		sszByteVector(transactionsRoot[:]),
+	}
+	if f >= forkCapella {
+		withdrawalsRoot := sszList(nil, p.maxWithdrawalsPerPayload).root
+		fields = append(fields, sszByteVector(withdrawalsRoot[:]))
+	}
+	if f >= forkDeneb {
+		fields = append(fields, sszUint64(uint64(h.BlobGasUsed)), sszUint64(uint64(h.ExcessBlobGas)))
+	}
+	return sszContainer(fields...)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"sort"
+)
+
+// scannedDeposit is a deposit entry along with where it was found
+type scannedDeposit struct {
+	index    uint64
+	block    uint64
+	txHash   common.Hash
+	logIndex uint
+	root     [32]byte
+	data     JSONData
+}
+
+// extractDeposits turns fetched transactions into deposit data entries, in block and log order. deposit_data_root is
+// computed from event fields, crossCheck additionally compares it with calldata of direct deposit calls.
+func extractDeposits(deposits *binding.Binding, addr common.Address, blockData []fetchBlockOutput, crossCheck bool) ([]scannedDeposit, error) {
+	contractAbi, err := binding.BindingMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	depositMethod := contractAbi.Methods["deposit"]
+
+	output := make([]scannedDeposit, 0)
+	depositEvent := contractAbi.Events["DepositEvent"]
+	for _, blk := range blockData {
+		for _, txData := range blk.data {
+			// receipt carries logs of every contract touched by the tx, a batch deposit leaves several
+			// deposit logs in it
+			logs := make([]*types.Log, 0, len(txData.logs))
+			for _, evnt := range txData.logs {
+				if evnt.Address == addr && len(evnt.Topics) > 0 && evnt.Topics[0] == depositEvent.ID {
+					logs = append(logs, evnt)
+				}
+			}
+			if len(logs) == 0 {
+				return nil, fmt.Errorf("no deposit event found in tx %s", txData.hash.Hex())
+			}
+			sort.Slice(logs, func(i, j int) bool { return logs[i].Index < logs[j].Index })
+
+			for _, evnt := range logs {
+				depEvent, err := deposits.ParseDepositEvent(*evnt)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse deposit event %d in tx %s: %w", evnt.Index, txData.hash.Hex(), err)
+				}
+				//  solc: bytes memory amount = to_little_endian_64(uint64(deposit_amount));
+				amount := binary.LittleEndian.Uint64(depEvent.Amount)
+				dataRoot, err := depositDataRoot(depEvent.Pubkey, depEvent.WithdrawalCredentials, amount, depEvent.Signature)
+				if err != nil {
+					return nil, fmt.Errorf("invalid deposit in tx %s: %w", txData.hash.Hex(), err)
+				}
+				// calldata of a direct call holds exactly one deposit
+				if crossCheck && len(logs) == 1 {
+					callRoot, ok, err := calldataDepositRoot(depositMethod, addr, txData)
+					if err != nil {
+						return nil, err
+					}
+					if ok && callRoot != dataRoot {
+						return nil, fmt.Errorf("deposit_data_root mismatch in tx %s: calldata %s, computed %s",
+							txData.hash.Hex(), hexutil.Encode(callRoot[:]), hexutil.Encode(dataRoot[:]))
+					}
+				}
+
+				if len(depEvent.Index) != 8 {
+					return nil, fmt.Errorf("invalid deposit index length %d in tx %s", len(depEvent.Index), txData.hash.Hex())
+				}
+
+				output = append(output, scannedDeposit{
+					// solc: to_little_endian_64(uint64(deposit_count))
+					index:    binary.LittleEndian.Uint64(depEvent.Index),
+					block:    blk.block,
+					txHash:   txData.hash,
+					logIndex: evnt.Index,
+					root:     dataRoot,
+					data: JSONData{
+						Pubkey:                hexutil.Encode(depEvent.Pubkey),
+						WithdrawalCredentials: hexutil.Encode(depEvent.WithdrawalCredentials),
+						Amount:                amount,
+						Signature:             hexutil.Encode(depEvent.Signature),
+						DepositDataRoot:       hexutil.Encode(dataRoot[:]),
+					},
+				})
+			}
+		}
+	}
+	return output, nil
+}
+
+// calldataDepositRoot reads deposit_data_root back from tx input. It is only available when the tx calls the
+// contract's deposit directly, ok is false otherwise.
+func calldataDepositRoot(depositMethod abi.Method, addr common.Address, txData fetchBlockEntry) (root [32]byte, ok bool, err error) {
+	if txData.to == nil || *txData.to != addr || !bytes.HasPrefix(txData.input, depositMethod.ID) {
+		return root, false, nil
+	}
+	// cut off first 4 bytes of method identifier
+	params, err := depositMethod.Inputs.Unpack(txData.input[4:])
+	if err != nil {
+		return root, false, err
+	}
+	root, ok = params[3].([32]byte)
+	if !ok {
+		return root, false, fmt.Errorf("got invalid type for data root in tx %s", txData.hash.Hex())
+	}
+	return root, true, nil
+}
+
+// orderDeposits sorts deposits by their contract index and makes sure they form a gapless sequence starting at
+// firstIndex. A single missing deposit means a wrong genesis, so any gap or duplicate is an error.
+func orderDeposits(deps []scannedDeposit, firstIndex uint64) error {
+	sort.SliceStable(deps, func(i, j int) bool { return deps[i].index < deps[j].index })
+
+	problems := make([]string, 0)
+	expected := firstIndex
+	for i, dep := range deps {
+		switch {
+		case i > 0 && dep.index == deps[i-1].index:
+			problems = append(problems, fmt.Sprintf("duplicate deposit %d (block %d, tx %s)", dep.index, dep.block, dep.txHash.Hex()))
+			continue
+		case dep.index == expected+1:
+			problems = append(problems, fmt.Sprintf("missing deposit %d before block %d", expected, dep.block))
+		case dep.index > expected:
+			problems = append(problems, fmt.Sprintf("missing deposits %d..%d before block %d", expected, dep.index-1, dep.block))
+		case dep.index < expected:
+			problems = append(problems, fmt.Sprintf("deposit %d is before first expected index %d", dep.index, firstIndex))
+			continue
+		}
+		expected = dep.index + 1
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return fmt.Errorf("deposit sequence is broken, %d problems found", len(problems))
+	}
+	return nil
+}
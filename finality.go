@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"strings"
+)
+
+// reorgRounds bounds rescans of blocks that keep changing while they are scanned
+const reorgRounds = 5
+
+// headNumber is the number of the block tag (latest, safe or finalized) points at. ok is false when the node
+// doesn't know the tag, pre-merge chains and nodes without a consensus client have no finalized block.
+func headNumber(ctx context.Context, client *rpc.Client, policy retryPolicy, tag string) (number uint64, ok bool, err error) {
+	var head *struct {
+		Number hexutil.Uint64 `json:"number"`
+	}
+	err = policy.do(ctx, tag+" block", func(ctx context.Context) error {
+		err := client.CallContext(ctx, &head, "eth_getBlockByNumber", tag, false)
+		if isUnknownTag(err, tag) {
+			head = nil
+			return nil
+		}
+		return err
+	})
+	if err != nil || head == nil {
+		return 0, false, err
+	}
+	return uint64(head.Number), true, nil
+}
+
+// isUnknownTag tells err is the node not knowing block tag. Older nodes take it for invalid params, geth before the
+// merge has no block to point it at. Other errors are left to the retry policy.
+func isUnknownTag(err error, tag string) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	// -32602 is the invalid params code of JSON-RPC
+	if rpcErr.ErrorCode() == -32602 {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	return strings.Contains(msg, tag+" block not found") || strings.Contains(msg, "unknown block") ||
+		strings.Contains(msg, "invalid block")
+}
+
+// resolveEnd picks the end block of a scan up to the head, exclusive. The scan covers the block tag points at,
+// less confirmations.
+func resolveEnd(ctx context.Context, client *rpc.Client, policy retryPolicy, tag string, confirmations uint64) (uint64, error) {
+	number, ok, err := headNumber(ctx, client, policy, tag)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("node has no %s block, try --head latest with --confirmations", tag)
+	}
+	if confirmations > number {
+		return 0, fmt.Errorf("%s block %d has less than %d confirmations", tag, number, confirmations)
+	}
+	return number - confirmations + 1, nil
+}
+
+// finalizedEnd is the first block that may still reorg. Without finalized block on the node, ok is false, the last
+// cacheFinalityDepth blocks below head are taken as such.
+func finalizedEnd(ctx context.Context, client *rpc.Client, policy retryPolicy) (next uint64, ok bool, err error) {
+	number, ok, err := headNumber(ctx, client, policy, "finalized")
+	if err != nil {
+		return 0, false, err
+	}
+	if ok {
+		return number + 1, true, nil
+	}
+	head, known, err := headNumber(ctx, client, policy, "latest")
+	if err != nil {
+		return 0, false, err
+	}
+	if !known {
+		return 0, false, errors.New("node has no latest block")
+	}
+	if head+1 < cacheFinalityDepth {
+		return 0, false, nil
+	}
+	return head + 1 - cacheFinalityDepth, false, nil
+}
+
+// scanUnfinalized scans blocks [from, to) that may still reorg. Block hashes are taken before the scan and again
+// after it, blocks from the first changed one on are scanned again until hashes hold still. Hashes of the blocks
+// scanned are returned along with them.
+func scanUnfinalized(ctx context.Context, f *fetcher, scan func(from, to uint64) ([]fetchBlockOutput, error), from, to uint64) ([]fetchBlockOutput, []common.Hash, error) {
+	before, err := f.canonicalHashes(ctx, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	output, err := scan(from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	for round := 0; ; round++ {
+		after, err := f.canonicalHashes(ctx, from, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed := -1
+		for i := range before {
+			if before[i] != after[i] {
+				changed = i
+				break
+			}
+		}
+		if changed < 0 {
+			return output, after, nil
+		}
+		reorged := from + uint64(changed)
+		if round == reorgRounds {
+			return nil, nil, fmt.Errorf("block %d keeps changing after %d rescans", reorged, reorgRounds)
+		}
+		fmt.Printf("block %d changed during the scan, rescanning blocks %d..%d\n", reorged, reorged, to-1)
+		rescanned, err := scan(reorged, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		kept := make([]fetchBlockOutput, 0, len(output))
+		for _, blk := range output {
+			if blk.block < reorged {
+				kept = append(kept, blk)
+			}
+		}
+		output = append(kept, rescanned...)
+		before = after
+	}
+}
+
+// canonicalHashes are hashes of blocks [from, to) as the node has them now
+func (f *fetcher) canonicalHashes(ctx context.Context, from, to uint64) ([]common.Hash, error) {
+	blocks := make([]uint64, 0, to-from)
+	for block := from; block < to; block++ {
+		blocks = append(blocks, block)
+	}
+	var hashes []common.Hash
+	err := f.policy.do(ctx, fmt.Sprintf("hashes of blocks %d..%d", from, to-1), func(ctx context.Context) error {
+		var err error
+		hashes, err = f.blockHashes(ctx, blocks)
+		return err
+	})
+	return hashes, err
+}
+
+// blockHashes takes hashes as the node reports them, a header hashed locally may lack fields of newer forks. Calls
+// are batched the same way blocks are.
+func (f *fetcher) blockHashes(ctx context.Context, blocks []uint64) ([]common.Hash, error) {
+	hashes := make([]common.Hash, 0, len(blocks))
+	size := f.jobSize()
+	for start := 0; start < len(blocks); start += size {
+		end := start + size
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		headers := make([]*struct {
+			Hash common.Hash `json:"hash"`
+		}, end-start)
+		calls := make([]rpc.BatchElem, end-start)
+		for i := range calls {
+			calls[i] = rpc.BatchElem{
+				Method: "eth_getBlockByNumber",
+				Args:   []interface{}{hexutil.EncodeUint64(blocks[start+i]), false},
+				Result: &headers[i],
+			}
+		}
+		var err error
+		if len(calls) == 1 {
+			err = f.rpc.CallContext(ctx, calls[0].Result, calls[0].Method, calls[0].Args...)
+		} else {
+			err = f.rpc.BatchCallContext(ctx, calls)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range calls {
+			if calls[i].Error != nil {
+				return nil, calls[i].Error
+			}
+			if headers[i] == nil {
+				return nil, fmt.Errorf("block %d not found", blocks[start+i])
+			}
+			hashes = append(hashes, headers[i].Hash)
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"testing"
+)
+
+// TestFinalizedEnd takes the finalized block when the node has one, the blocks within cacheFinalityDepth of the head
+// otherwise, however deep the scanned range is
+func TestFinalizedEnd(t *testing.T) {
+	for _, tc := range []struct {
+		finalized *uint64
+		head      uint64
+		next      uint64
+		ok        bool
+	}{
+		{finalized: new(uint64), head: 1000, next: 1, ok: true},
+		{finalized: nil, head: 1000, next: 1001 - cacheFinalityDepth},
+		{finalized: nil, head: 100, next: 0},
+	} {
+		finalized, head := tc.finalized, tc.head
+		_, srv := newRPCStub(t, map[string]rpcHandler{
+			"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, error) {
+				var tag string
+				err := json.Unmarshal(params[0], &tag)
+				if err != nil {
+					return nil, err
+				}
+				switch {
+				case tag == "latest":
+					return map[string]interface{}{"number": hexutil.Uint64(head)}, nil
+				case tag == "finalized" && finalized != nil:
+					return map[string]interface{}{"number": hexutil.Uint64(*finalized)}, nil
+				}
+				return nil, errors.New("finalized block not found")
+			},
+		})
+		client, err := rpc.Dial(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, ok, err := finalizedEnd(context.Background(), client, testPolicy())
+		client.Close()
+		if err != nil || next != tc.next || ok != tc.ok {
+			t.Errorf("head %d: got %d, %v, %v, want %d, %v", head, next, ok, err, tc.next, tc.ok)
+		}
+	}
+}
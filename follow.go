@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"time"
+)
+
+// follow keeps deposit data growing along with the chain until ctx is done. Every --follow-interval, or sooner when
+// a deposit event arrives, blocks from end up to the new head are scanned the same way the first scan went and
+// their deposits appended. Blocks past the finalized one may still reorg, their hashes are checked every round and
+// deposits from the first changed block on are dropped and scanned again. Failures are warned about and the blocks
+// scanned again on the next round, only deposits not matching the contract end it.
+func follow(ctx context.Context, s *scanner, scanned []scannedDeposit, end uint64, version forkVersion) error {
+	opts := s.opts
+	registered := registeredPubkeys(scanned)
+	// blocks [tailFrom, end) may still reorg, tail holds their hashes as scanned
+	tailFrom, tail := s.tailFrom, s.tailHashes
+	wake := make(chan struct{}, 1)
+	go watchDeposits(ctx, s.deposits, s.policy, opts.redact, wake)
+	ticker := time.NewTicker(opts.followInterval)
+	defer ticker.Stop()
+	fmt.Printf("following the chain from block %d\n", end)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-wake:
+		}
+		next, err := resolveEnd(ctx, s.rpc, s.policy, opts.head, opts.confirmations)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// errors of the http transport carry the full URL along with the API key
+			fmt.Printf("warning: %s\n", opts.redact(err.Error()))
+			continue
+		}
+		from, err := reorgedFrom(ctx, s.f, tailFrom, tail, end)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("warning: %s\n", opts.redact(err.Error()))
+			continue
+		}
+		if from < end {
+			fmt.Printf("block %d reorged, scanning again from it\n", from)
+		}
+		if next <= from {
+			continue
+		}
+		kept := scanned
+		for len(kept) > 0 && kept[len(kept)-1].block >= from {
+			kept = kept[:len(kept)-1]
+		}
+		all, err := s.followRound(ctx, kept, from, next)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errDepositMismatch) {
+			return err
+		}
+		if err != nil {
+			// blocks are scanned again on the next round
+			fmt.Printf("warning: %s\n", opts.redact(err.Error()))
+			continue
+		}
+		// blocks kept from the tail stay in it while unfinalized, the round adds its own from block from on
+		keptFrom := s.finalEnd
+		if keptFrom < tailFrom {
+			keptFrom = tailFrom
+		}
+		if keptFrom < from {
+			tail = append(tail[keptFrom-tailFrom:from-tailFrom:from-tailFrom], s.tailHashes...)
+			tailFrom = keptFrom
+		} else {
+			tailFrom, tail = s.tailFrom, s.tailHashes
+		}
+		if len(kept) < len(scanned) {
+			registered = registeredPubkeys(kept)
+		}
+		found := all[len(kept):]
+		invalid := markValidFollowing(found, registered, version)
+		dropped := len(scanned) - len(kept)
+		scanned, end = all, next
+		if len(found) == 0 && dropped == 0 {
+			continue
+		}
+		written, err := writeDepositData(opts.output, scanned, opts.excludeInvalid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("block %d: %d new deposits, %d with invalid signature, %d dropped by reorg, %d deposits written\n",
+			end-1, len(found), invalid, dropped, written)
+	}
+}
+
+// reorgedFrom is the first block of [tailFrom, end) whose hash differs from tail, end when none does
+func reorgedFrom(ctx context.Context, f *fetcher, tailFrom uint64, tail []common.Hash, end uint64) (uint64, error) {
+	if len(tail) == 0 {
+		return end, nil
+	}
+	now, err := f.canonicalHashes(ctx, tailFrom, end)
+	if err != nil {
+		return 0, err
+	}
+	for i := range tail {
+		if tail[i] != now[i] {
+			return tailFrom + uint64(i), nil
+		}
+	}
+	return end, nil
+}
+
+// followRound scans blocks [end, next) and returns scanned along with deposits found in them, checked against the
+// contract at next-1
+func (s *scanner) followRound(ctx context.Context, scanned []scannedDeposit, end, next uint64) ([]scannedDeposit, error) {
+	opts := s.opts
+	blockData, err := s.scan(ctx, end, next, nil)
+	if err != nil {
+		return nil, err
+	}
+	found, err := extractDeposits(s.deposits, s.addr, blockData, opts.crossCheckCalldata)
+	if err != nil {
+		return nil, err
+	}
+	err = orderDeposits(found, opts.firstIndex+uint64(len(scanned)))
+	if err != nil {
+		return nil, err
+	}
+	all := append(append(make([]scannedDeposit, 0, len(scanned)+len(found)), scanned...), found...)
+	err = verifyDepositRoot(ctx, s.deposits, s.policy, next-1, all, opts.firstIndex)
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// watchDeposits nudges wake on every new deposit event. The subscription is made again whenever it drops, events
+// missed meanwhile don't matter as the scan picks up from the last scanned block. Clients without subscriptions,
+// HTTP ones, leave follow to polling. Errors are printed through redact.
+func watchDeposits(ctx context.Context, deposits *binding.Binding, policy retryPolicy, redact func(string) string, wake chan<- struct{}) {
+	delay := policy.baseDelay
+	for ctx.Err() == nil {
+		events := make(chan *binding.BindingDepositEvent)
+		sub, err := deposits.WatchDepositEvent(&bind.WatchOpts{Context: ctx}, events)
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			return
+		}
+		if err == nil {
+			delay = policy.baseDelay
+			err = forwardDeposits(ctx, sub.Err(), events, wake)
+			sub.Unsubscribe()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("warning: deposit subscription failed: %s, subscribing again in %s\n", redact(err.Error()), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > policy.maxDelay {
+			delay = policy.maxDelay
+		}
+	}
+}
+
+func forwardDeposits(ctx context.Context, errs <-chan error, events <-chan *binding.BindingDepositEvent, wake chan<- struct{}) error {
+	lastBlock := uint64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			// error channel is closed without an error once the subscription is gone
+			if err == nil {
+				err = errors.New("subscription ended")
+			}
+			return err
+		case evnt := <-events:
+			// removed events are of reorged blocks, the scan notices those on its own
+			if evnt.Raw.Removed {
+				continue
+			}
+			if evnt.Raw.BlockNumber != lastBlock {
+				lastBlock = evnt.Raw.BlockNumber
+				fmt.Printf("deposits seen in block %d\n", lastBlock)
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
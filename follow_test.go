@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// followChain is a chain of at most one deposit per block, served through eth_getLogs and the deposit count and
+// root calls of the contract. Block 1 is finalized.
+type followChain struct {
+	contract common.Address
+	mut      sync.Mutex
+	head     uint64
+	// deposits holds the deposit of each block, -1 for none, forks the fork each block is on
+	deposits []int
+	forks    []int
+	all      []scannedDeposit
+}
+
+func (c *followChain) hash(block uint64) common.Hash {
+	return common.BigToHash(big.NewInt(int64(block*1000) + int64(c.forks[block])))
+}
+
+// canonical is deposits of blocks up to block, numbered from 0
+func (c *followChain) canonical(block uint64) []scannedDeposit {
+	deps := make([]scannedDeposit, 0)
+	for number := uint64(0); number <= block; number++ {
+		if c.deposits[number] >= 0 {
+			dep := c.all[c.deposits[number]]
+			dep.index, dep.block = uint64(len(deps)), number
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+func (c *followChain) handlers(t *testing.T, latest func() uint64) map[string]rpcHandler {
+	contractAbi, err := binding.BindingMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	countMethod, rootMethod := contractAbi.Methods["get_deposit_count"], contractAbi.Methods["get_deposit_root"]
+	return map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, error) {
+			var tag string
+			err := json.Unmarshal(params[0], &tag)
+			if err != nil {
+				return nil, err
+			}
+			var number uint64
+			switch tag {
+			case "latest":
+				number = latest()
+			case "finalized":
+				number = 1
+			default:
+				number, err = hexutil.DecodeUint64(tag)
+				if err != nil {
+					return nil, err
+				}
+			}
+			c.mut.Lock()
+			defer c.mut.Unlock()
+			if number > c.head {
+				return nil, nil
+			}
+			return map[string]interface{}{"number": hexutil.Uint64(number), "hash": c.hash(number)}, nil
+		},
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, error) {
+			var filter struct {
+				FromBlock hexutil.Uint64 `json:"fromBlock"`
+				ToBlock   hexutil.Uint64 `json:"toBlock"`
+			}
+			err := json.Unmarshal(params[0], &filter)
+			if err != nil {
+				return nil, err
+			}
+			c.mut.Lock()
+			defer c.mut.Unlock()
+			logs := make([]*types.Log, 0)
+			for _, dep := range c.canonical(uint64(filter.ToBlock)) {
+				if dep.block >= uint64(filter.FromBlock) {
+					l := depositEventLog(t, c.contract, dep.data, dep.index, dep.block, c.hash(dep.block), 0)
+					l.BlockHash = c.hash(dep.block)
+					logs = append(logs, l)
+				}
+			}
+			return logs, nil
+		},
+		"eth_call": func(params []json.RawMessage) (interface{}, error) {
+			var call struct {
+				Input hexutil.Bytes `json:"data"`
+			}
+			var block hexutil.Uint64
+			err := json.Unmarshal(params[0], &call)
+			if err == nil {
+				err = json.Unmarshal(params[1], &block)
+			}
+			if err != nil {
+				return nil, err
+			}
+			c.mut.Lock()
+			deps := c.canonical(uint64(block))
+			c.mut.Unlock()
+			var out []byte
+			if string(call.Input[:4]) == string(countMethod.ID) {
+				out, err = countMethod.Outputs.Pack(binary.LittleEndian.AppendUint64(nil, uint64(len(deps))))
+			} else {
+				var root [32]byte
+				root, err = depositRoot(deps)
+				if err == nil {
+					out, err = rootMethod.Outputs.Pack(root)
+				}
+			}
+			return hexutil.Bytes(out), err
+		},
+	}
+}
+
+// TestFollowReorg follows a chain whose block 3 reorgs two rounds after it was scanned, its deposit is replaced by
+// another one
+func TestFollowReorg(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	chain := &followChain{contract: contract, head: 3, deposits: []int{-1, 0, -1, 1, -1, 3, 4, -1},
+		forks: make([]int, 8), all: genesisTestDeposits(t, nil)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rounds := 0
+	latest := func() uint64 {
+		chain.mut.Lock()
+		defer chain.mut.Unlock()
+		rounds++
+		switch rounds {
+		case 1:
+			chain.head = 5
+		case 2:
+			chain.head = 7
+			chain.deposits[3], chain.forks[3] = 2, 1
+		default:
+			cancel()
+		}
+		return chain.head
+	}
+	_, srv := newRPCStub(t, chain.handlers(t, latest))
+	client, err := rpc.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	deposits, err := binding.NewBinding(contract, ethclient.NewClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &options{head: "latest", followInterval: time.Millisecond, scanLogs: true,
+		output: filepath.Join(t.TempDir(), "deposit_data.json")}
+	s := &scanner{opts: opts, rpc: client, eth: ethclient.NewClient(client), deposits: deposits, addr: contract,
+		policy: testPolicy(), f: newFetcher(client, testPolicy(), 0)}
+
+	// first scan leaves blocks 2 and 3 unfinalized, rounds scan 4..5 and 6..7
+	scanned, err := s.followRound(ctx, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.tailFrom != 2 || len(s.tailHashes) != 2 {
+		t.Fatalf("tail of %d blocks from %d", len(s.tailHashes), s.tailFrom)
+	}
+	err = follow(ctx, s, scanned, 4, forkVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(opts.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []JSONData
+	err = json.Unmarshal(raw, &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 2, 3, 4}
+	if len(written) != len(want) {
+		t.Fatalf("wrote %d deposits, want %d", len(written), len(want))
+	}
+	for i, dep := range want {
+		if written[i].Pubkey != chain.all[dep].data.Pubkey {
+			t.Errorf("deposit %d is %s, want %s", i, written[i].Pubkey, chain.all[dep].data.Pubkey)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"os"
+)
+
+const farFutureEpoch = ^uint64(0)
+const genesisEpoch = uint64(0)
+
+type validator struct {
+	pubkey                     [48]byte
+	withdrawalCredentials      [32]byte
+	effectiveBalance           uint64
+	slashed                    bool
+	activationEligibilityEpoch uint64
+	activationEpoch            uint64
+	exitEpoch                  uint64
+	withdrawableEpoch          uint64
+}
+
+func (v *validator) ssz() sszValue {
+	return sszContainer(
+		sszByteVector(v.pubkey[:]),
+		sszByteVector(v.withdrawalCredentials[:]),
+		sszUint64(v.effectiveBalance),
+		sszBool(v.slashed),
+		sszUint64(v.activationEligibilityEpoch),
+		sszUint64(v.activationEpoch),
+		sszUint64(v.exitEpoch),
+		sszUint64(v.withdrawableEpoch),
+	)
+}
+
+type eth1Data struct {
+	depositRoot  [32]byte
+	depositCount uint64
+	blockHash    common.Hash
+}
+
+func (e *eth1Data) ssz() sszValue {
+	return sszContainer(sszByteVector(e.depositRoot[:]), sszUint64(e.depositCount), sszByteVector(e.blockHash[:]))
+}
+
+// genesisState is a BeaconState right after initialize_beacon_state_from_eth1 of the genesis fork. Everything not
+// kept here is zero at genesis.
+type genesisState struct {
+	cfg                   *chainConfig
+	fork                  fork
+	version               forkVersion
+	genesisTime           uint64
+	genesisValidatorsRoot [32]byte
+	eth1Data              eth1Data
+	eth1DepositIndex      uint64
+	validators            []validator
+	balances              []uint64
+	// altair
+	syncCommittee *syncCommittee
+	// bellatrix
+	executionHeader *executionHeader
+}
+
+// initializeBeaconState runs initialize_beacon_state_from_eth1 of the configured genesis fork over deposits, which
+// have to be ordered and complete from index 0, with signatures checked by markValidDeposits. Invalid deposits still
+// count into eth1 data, but don't touch validators. header is only used from bellatrix on, nil stands for the default one.
+func initializeBeaconState(cfg *chainConfig, eth1BlockHash common.Hash, eth1Timestamp uint64, deps []scannedDeposit, header *executionHeader) (*genesisState, error) {
+	p := cfg.preset()
+	f, version := cfg.genesisFork()
+	if header == nil {
+		header = &executionHeader{}
+	}
+	state := &genesisState{
+		cfg:             cfg,
+		fork:            f,
+		version:         version,
+		genesisTime:     eth1Timestamp + cfg.GenesisDelay,
+		eth1Data:        eth1Data{depositCount: uint64(len(deps)), blockHash: eth1BlockHash},
+		validators:      make([]validator, 0, len(deps)),
+		balances:        make([]uint64, 0, len(deps)),
+		executionHeader: header,
+	}
+
+	// deposit tree root after each deposit is the hash_tree_root(List[DepositData, 2**32]) the spec asks for
+	tree := newDepositTree()
+	indices := make(map[[48]byte]int, len(deps))
+	for i, dep := range deps {
+		if dep.index != uint64(i) {
+			return nil, fmt.Errorf("genesis needs all deposits from index 0, got %d at position %d", dep.index, i)
+		}
+		err := tree.push(dep.root)
+		if err != nil {
+			return nil, err
+		}
+		state.eth1Data.depositRoot = tree.root()
+
+		// process_deposit, proof holds by construction. Electra queues deposits as pending ones and applies them
+		// right after, which adds up to the same balances.
+		state.eth1DepositIndex++
+		if !dep.data.Valid {
+			continue
+		}
+		pubkey, withdrawalCredentials, _, err := dep.data.decode()
+		if err != nil {
+			return nil, fmt.Errorf("deposit %d: %w", dep.index, err)
+		}
+		amount := dep.data.Amount
+		if idx, ok := indices[pubkey]; ok {
+			state.balances[idx] += amount
+			continue
+		}
+		indices[pubkey] = len(state.validators)
+		state.validators = append(state.validators, validator{
+			pubkey:                     pubkey,
+			withdrawalCredentials:      withdrawalCredentials,
+			activationEligibilityEpoch: farFutureEpoch,
+			activationEpoch:            farFutureEpoch,
+			exitEpoch:                  farFutureEpoch,
+			withdrawableEpoch:          farFutureEpoch,
+		})
+		state.balances = append(state.balances, amount)
+	}
+
+	// process activations
+	for i := range state.validators {
+		v := &state.validators[i]
+		var active bool
+		v.effectiveBalance, active = genesisActivation(f, p, v.withdrawalCredentials, state.balances[i])
+		if active {
+			v.activationEligibilityEpoch = genesisEpoch
+			v.activationEpoch = genesisEpoch
+		}
+	}
+	state.genesisValidatorsRoot = state.validatorsSSZ().root
+
+	// current and next sync committee are the same at genesis
+	if f >= forkAltair {
+		committee, err := state.nextSyncCommittee()
+		if err != nil {
+			return nil, err
+		}
+		state.syncCommittee = committee
+	}
+	return state, nil
+}
+
+// genesisActivation is the effective balance of a validator with balance at genesis of fork f, and whether it is
+// active from genesis epoch
+func genesisActivation(f fork, p preset, withdrawalCredentials [32]byte, balance uint64) (uint64, bool) {
+	maxEffectiveBalance, activationBalance := p.maxEffectiveBalance, p.maxEffectiveBalance
+	if f >= forkElectra {
+		maxEffectiveBalance, activationBalance = p.minActivationBalance, p.minActivationBalance
+		// compounding withdrawal credentials
+		if withdrawalCredentials[0] == 0x02 {
+			maxEffectiveBalance = p.maxEffectiveBalanceElectra
+		}
+	}
+	effectiveBalance := balance - balance%p.effectiveBalanceIncrement
+	if effectiveBalance > maxEffectiveBalance {
+		effectiveBalance = maxEffectiveBalance
+	}
+	return effectiveBalance, effectiveBalance >= activationBalance
+}
+
+func (s *genesisState) validatorsSSZ() sszValue {
+	elems := make([]sszValue, len(s.validators))
+	for i := range s.validators {
+		elems[i] = s.validators[i].ssz()
+	}
+	return sszList(elems, s.cfg.preset().validatorRegistryLimit)
+}
+
+func (s *genesisState) activeValidatorCount() uint64 {
+	count := uint64(0)
+	for _, v := range s.validators {
+		if v.activationEpoch == genesisEpoch {
+			count++
+		}
+	}
+	return count
+}
+
+// validate reports what is_valid_genesis_state of the spec would reject
+func (s *genesisState) validate() []string {
+	problems := make([]string, 0)
+	if s.genesisTime < s.cfg.MinGenesisTime {
+		problems = append(problems, fmt.Sprintf("genesis time %d is before MIN_GENESIS_TIME %d", s.genesisTime, s.cfg.MinGenesisTime))
+	}
+	if active := s.activeValidatorCount(); active < s.cfg.MinGenesisActiveValidatorCount {
+		problems = append(problems, fmt.Sprintf("%d active validators, MIN_GENESIS_ACTIVE_VALIDATOR_COUNT is %d", active, s.cfg.MinGenesisActiveValidatorCount))
+	}
+	return problems
+}
+
+// ssz encodes the BeaconState container of the genesis fork
+func (s *genesisState) ssz() sszValue {
+	p := s.cfg.preset()
+	zeroRoot := sszByteVector(make([]byte, 32))
+	zeroCheckpoint := sszContainer(sszUint64(0), zeroRoot)
+
+	historicalRoots := make([]sszValue, p.slotsPerHistoricalRoot)
+	for i := range historicalRoots {
+		historicalRoots[i] = zeroRoot
+	}
+	randaoMixes := make([]sszValue, p.epochsPerHistoricalVector)
+	for i := range randaoMixes {
+		randaoMixes[i] = sszByteVector(s.eth1Data.blockHash[:])
+	}
+	bodyRoot := beaconBlockBodyRoot(s.fork, p)
+
+	fields := []sszValue{
+		sszUint64(s.genesisTime),
+		sszByteVector(s.genesisValidatorsRoot[:]),
+		// slot
+		sszUint64(0),
+		// fork
+		sszContainer(sszByteVector(s.version[:]), sszByteVector(s.version[:]), sszUint64(genesisEpoch)),
+		// latest_block_header
+		sszContainer(sszUint64(0), sszUint64(0), zeroRoot, zeroRoot, sszByteVector(bodyRoot[:])),
+		// block_roots, state_roots
+		sszVector(historicalRoots),
+		sszVector(historicalRoots),
+		// historical_roots
+		sszList(nil, p.historicalRootsLimit),
+		s.eth1Data.ssz(),
+		// eth1_data_votes
+		sszList(nil, p.epochsPerEth1VotingPeriod*p.slotsPerEpoch),
+		sszUint64(s.eth1DepositIndex),
+		s.validatorsSSZ(),
+		sszUint64List(s.balances, p.validatorRegistryLimit),
+		sszVector(randaoMixes),
+		// slashings
+		sszUint64Vector(make([]uint64, p.epochsPerSlashingsVector)),
+	}
+	if s.fork == forkPhase0 {
+		// previous_epoch_attestations, current_epoch_attestations
+		fields = append(fields, sszList(nil, p.maxAttestations*p.slotsPerEpoch), sszList(nil, p.maxAttestations*p.slotsPerEpoch))
+	} else {
+		// previous_epoch_participation, current_epoch_participation
+		participation := sszByteList(make([]byte, len(s.validators)), p.validatorRegistryLimit)
+		fields = append(fields, participation, participation)
+	}
+	fields = append(fields,
+		// justification_bits
+		sszBitvector([]byte{0}),
+		// previous_justified_checkpoint, current_justified_checkpoint, finalized_checkpoint
+		zeroCheckpoint,
+		zeroCheckpoint,
+		zeroCheckpoint,
+	)
+	if s.fork >= forkAltair {
+		committee := s.syncCommittee.ssz()
+		fields = append(fields,
+			// inactivity_scores
+			sszUint64List(make([]uint64, len(s.validators)), p.validatorRegistryLimit),
+			// current_sync_committee, next_sync_committee
+			committee,
+			committee,
+		)
+	}
+	if s.fork >= forkBellatrix {
+		fields = append(fields, s.executionHeader.ssz(s.fork, p))
+	}
+	if s.fork >= forkCapella {
+		fields = append(fields,
+			// next_withdrawal_index, next_withdrawal_validator_index
+			sszUint64(0),
+			sszUint64(0),
+			// historical_summaries
+			sszList(nil, p.historicalSummariesLimit),
+		)
+	}
+	if s.fork >= forkElectra {
+		fields = append(fields,
+			// deposit_requests_start_index, unset
+			sszUint64(^uint64(0)),
+			// deposit_balance_to_consume, exit_balance_to_consume, earliest_exit_epoch,
+			// consolidation_balance_to_consume, earliest_consolidation_epoch
+			sszUint64(0),
+			sszUint64(0),
+			sszUint64(0),
+			sszUint64(0),
+			sszUint64(0),
+			// pending_deposits, emptied at genesis, pending_partial_withdrawals, pending_consolidations
+			sszList(nil, p.pendingDepositsLimit),
+			sszList(nil, p.pendingPartialWithdrawalsLimit),
+			sszList(nil, p.pendingConsolidationsLimit),
+		)
+	}
+	return sszContainer(fields...)
+}
+
+// beaconBlockBodyRoot is hash_tree_root(BeaconBlockBody()) of fork f
+func beaconBlockBodyRoot(f fork, p preset) [32]byte {
+	emptyEth1Data := eth1Data{}
+	maxAttesterSlashings, maxAttestations := p.maxAttesterSlashings, p.maxAttestations
+	if f >= forkElectra {
+		maxAttesterSlashings, maxAttestations = p.maxAttesterSlashingsElectra, p.maxAttestationsElectra
+	}
+	fields := []sszValue{
+		// randao_reveal
+		sszByteVector(make([]byte, 96)),
+		emptyEth1Data.ssz(),
+		// graffiti
+		sszByteVector(make([]byte, 32)),
+		// proposer_slashings, attester_slashings, attestations, deposits, voluntary_exits
+		sszList(nil, p.maxProposerSlashings),
+		sszList(nil, maxAttesterSlashings),
+		sszList(nil, maxAttestations),
+		sszList(nil, p.maxDeposits),
+		sszList(nil, p.maxVoluntaryExits),
+	}
+	if f >= forkAltair {
+		// sync_aggregate
+		fields = append(fields, sszContainer(sszBitvector(make([]byte, p.syncCommitteeSize/8)), sszByteVector(make([]byte, 96))))
+	}
+	if f >= forkBellatrix {
+		// execution_payload, same root as the default header
+		emptyHeader := executionHeader{}
+		fields = append(fields, emptyHeader.ssz(f, p))
+	}
+	if f >= forkCapella {
+		// bls_to_execution_changes
+		fields = append(fields, sszList(nil, p.maxBlsToExecutionChanges))
+	}
+	if f >= forkDeneb {
+		// blob_kzg_commitments
+		fields = append(fields, sszList(nil, p.maxBlobCommitmentsPerBlock))
+	}
+	if f >= forkElectra {
+		// execution_requests: deposits, withdrawals, consolidations
+		fields = append(fields, sszContainer(
+			sszList(nil, p.maxDepositRequestsPerPayload),
+			sszList(nil, p.maxWithdrawalRequestsPerPayload),
+			sszList(nil, p.maxConsolidationRequestsPerPayload),
+		))
+	}
+	return sszContainer(fields...).root
+}
+
+type blockInfo struct {
+	Number    hexutil.Uint64 `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Timestamp hexutil.Uint64 `json:"timestamp"`
+}
+
+// fetchBlockInfo reads block hash as reported by the node. Header types of this go-ethereum version don't know
+// fields of later forks, so hash can't be computed locally.
+func fetchBlockInfo(ctx context.Context, client *rpc.Client, block uint64) (*blockInfo, error) {
+	var info *blockInfo
+	err := client.CallContext(ctx, &info, "eth_getBlockByNumber", hexutil.EncodeBig(new(big.Int).SetUint64(block)), false)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("block %d not found", block)
+	}
+	return info, nil
+}
+
+// writeGenesis builds the genesis state with the given block as eth1 block and writes it SSZ encoded to path.
+// headerPath points to the execution block JSON for latest_execution_payload_header, empty keeps the default one.
+func writeGenesis(ctx context.Context, client *rpc.Client, cfg *chainConfig, block uint64, deps []scannedDeposit, headerPath string, path string) error {
+	info, err := fetchBlockInfo(ctx, client, block)
+	if err != nil {
+		return fmt.Errorf("failed to fetch eth1 block %d: %w", block, err)
+	}
+	return writeGenesisState(cfg, info, deps, headerPath, path)
+}
+
+// writeGenesisState is writeGenesis with the eth1 block at hand
+func writeGenesisState(cfg *chainConfig, info *blockInfo, deps []scannedDeposit, headerPath string, path string) error {
+	var err error
+	var header *executionHeader
+	if headerPath != "" {
+		header, err = loadExecutionHeader(headerPath)
+		if err != nil {
+			return err
+		}
+	}
+	state, err := initializeBeaconState(cfg, info.Hash, uint64(info.Timestamp), deps, header)
+	if err != nil {
+		return err
+	}
+	for _, problem := range state.validate() {
+		fmt.Printf("warning: not a valid genesis state, %s\n", problem)
+	}
+	encoded := state.ssz()
+	err = os.WriteFile(path, encoded.data, 0600)
+	if err != nil {
+		return err
+	}
+	if header != nil && state.fork < forkBellatrix {
+		fmt.Printf("warning: execution header not used, genesis fork %s has no execution payload\n", state.fork)
+	}
+	fmt.Printf("Genesis state written to %s\n fork: %s\n eth1 block: %d %s\n genesis time: %d\n validators: %d (%d active)\n genesis validators root: %s\n state root: %s\n",
+		path, state.fork, uint64(info.Number), info.Hash.Hex(), state.genesisTime, len(state.validators), state.activeValidatorCount(),
+		hexutil.Encode(state.genesisValidatorsRoot[:]), hexutil.Encode(encoded.root[:]))
+	return nil
+}
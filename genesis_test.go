@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"testing"
+)
+
+// genesisTestDeposits takes the first 64 well formed deposits of deposit_data.json, all marked valid. edit may
+// change them before their roots are computed.
+func genesisTestDeposits(t *testing.T, edit func(data []JSONData)) []scannedDeposit {
+	t.Helper()
+	data := make([]JSONData, 0, 64)
+	for _, d := range loadDepositData(t) {
+		if len(data) == cap(data) {
+			break
+		}
+		if _, _, _, err := d.decode(); err == nil {
+			d.Valid = true
+			data = append(data, d)
+		}
+	}
+	if edit != nil {
+		edit(data)
+	}
+	deps := make([]scannedDeposit, len(data))
+	for i, d := range data {
+		pubkey, withdrawalCredentials, signature, err := d.decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := depositDataRoot(pubkey[:], withdrawalCredentials[:], d.Amount, signature[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		deps[i] = scannedDeposit{index: uint64(i), root: root, data: d}
+	}
+	return deps
+}
+
+// genesisTestConfig is the minimal preset with every fork from altair on scheduled at epoch
+func genesisTestConfig(altair, bellatrix, capella, deneb, electra uint64) *chainConfig {
+	return &chainConfig{
+		PresetBase:                     "minimal",
+		MinGenesisActiveValidatorCount: 64,
+		GenesisForkVersion:             forkVersion{0x00, 0x00, 0x00, 0x01},
+		AltairForkVersion:              forkVersion{0x01, 0x00, 0x00, 0x01},
+		BellatrixForkVersion:           forkVersion{0x02, 0x00, 0x00, 0x01},
+		CapellaForkVersion:             forkVersion{0x03, 0x00, 0x00, 0x01},
+		DenebForkVersion:               forkVersion{0x04, 0x00, 0x00, 0x01},
+		ElectraForkVersion:             forkVersion{0x05, 0x00, 0x00, 0x01},
+		GenesisDelay:                   300,
+		AltairForkEpoch:                altair,
+		BellatrixForkEpoch:             bellatrix,
+		CapellaForkEpoch:               capella,
+		DenebForkEpoch:                 deneb,
+		ElectraForkEpoch:               electra,
+	}
+}
+
+func genesisTestBlockHash() common.Hash {
+	var blockHash common.Hash
+	for i := range blockHash {
+		blockHash[i] = 0x12
+	}
+	return blockHash
+}
+
+func checkGenesisStateRoot(t *testing.T, state *genesisState, want string) {
+	t.Helper()
+	root := state.ssz().root
+	if got := hexutil.Encode(root[:]); got != want {
+		t.Fatalf("%s state root %s, want %s", state.fork, got, want)
+	}
+}
+
+// TestGenesisStateRoot builds a phase0 genesis of the minimal preset out of the first 64 well formed deposits of
+// deposit_data.json. Expected root is the one zrnt gives for the same deposits with signatures left unchecked.
+func TestGenesisStateRoot(t *testing.T) {
+	cfg := genesisTestConfig(farFutureEpoch, farFutureEpoch, farFutureEpoch, farFutureEpoch, farFutureEpoch)
+	state, err := initializeBeaconState(cfg, genesisTestBlockHash(), 1600000000, genesisTestDeposits(t, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := state.validate(); len(problems) > 0 {
+		t.Fatalf("not a valid genesis state: %v", problems)
+	}
+	if state.genesisTime != 1600000300 {
+		t.Fatalf("genesis time %d", state.genesisTime)
+	}
+	if active := state.activeValidatorCount(); active != 64 {
+		t.Fatalf("%d active validators", active)
+	}
+	checkGenesisStateRoot(t, state, "0x0f995685dcde0dd6815f3ce08db551b7c4ec8c2fc4ff48e82cde34ef68d88dbd")
+}
+
+// TestAltairGenesisStateRoot is TestGenesisStateRoot with altair at genesis, which adds participation, inactivity
+// scores and the sync committee. Expected root is zrnt's phase0 genesis upgraded to altair, with fork and latest
+// block header set the way genesis of altair has them.
+func TestAltairGenesisStateRoot(t *testing.T) {
+	cfg := genesisTestConfig(genesisEpoch, farFutureEpoch, farFutureEpoch, farFutureEpoch, farFutureEpoch)
+	state, err := initializeBeaconState(cfg, genesisTestBlockHash(), 1600000000, genesisTestDeposits(t, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.fork != forkAltair || len(state.syncCommittee.pubkeys) != 32 {
+		t.Fatalf("fork %s, sync committee of %d", state.fork, len(state.syncCommittee.pubkeys))
+	}
+	checkGenesisStateRoot(t, state, "0x0b6ff13d50886cbacac9611fd134b05d1e39a3a212fa63cd1212a6e5acaaabd9")
+}
+
+// TestElectraGenesisStateRoot has every fork up to electra at genesis, with an execution payload header and deposits
+// that only electra treats differently: a compounding validator of 64 ETH topped up by 1 ETH and a 40 ETH one that
+// stays at 32 ETH effective balance. Expected root is a regression value, not an independent known answer: zrnt
+// can't upgrade to electra, the root was checked against its deneb genesis moved into electra's state with the
+// electra rules applied by hand, which shares this code's reading of the spec. Balances and effective balances are
+// checked on their own.
+func TestElectraGenesisStateRoot(t *testing.T) {
+	cfg := genesisTestConfig(genesisEpoch, genesisEpoch, genesisEpoch, genesisEpoch, genesisEpoch)
+	deps := genesisTestDeposits(t, func(data []JSONData) {
+		data[0].WithdrawalCredentials = "0x02" + data[0].WithdrawalCredentials[4:]
+		data[0].Amount = 64000000000
+		data[1].Amount = 40000000000
+		data[2].Pubkey = data[0].Pubkey
+		data[2].Amount = 1000000000
+	})
+	header := &executionHeader{
+		ParentHash:    common.HexToHash("0x0101010101010101010101010101010101010101010101010101010101010101"),
+		FeeRecipient:  common.HexToAddress("0x0202020202020202020202020202020202020202"),
+		StateRoot:     common.HexToHash("0x0303030303030303030303030303030303030303030303030303030303030303"),
+		ReceiptsRoot:  common.HexToHash("0x0404040404040404040404040404040404040404040404040404040404040404"),
+		LogsBloom:     make(hexutil.Bytes, 256),
+		PrevRandao:    common.HexToHash("0x0505050505050505050505050505050505050505050505050505050505050505"),
+		GasLimit:      30000000,
+		Timestamp:     1600000300,
+		ExtraData:     hexutil.Bytes{0xde, 0xad, 0xbe, 0xef},
+		BaseFeePerGas: (*hexutil.Big)(big.NewInt(1000000000)),
+		BlockHash:     common.HexToHash("0x0606060606060606060606060606060606060606060606060606060606060606"),
+		BlobGasUsed:   131072,
+		ExcessBlobGas: 262144,
+	}
+	header.LogsBloom[0], header.LogsBloom[255] = 0x80, 0x01
+	state, err := initializeBeaconState(cfg, genesisTestBlockHash(), 1600000000, deps, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.fork != forkElectra || len(state.validators) != 63 {
+		t.Fatalf("fork %s, %d validators", state.fork, len(state.validators))
+	}
+	if state.balances[0] != 65000000000 || state.validators[0].effectiveBalance != 65000000000 || state.validators[1].effectiveBalance != 32000000000 {
+		t.Fatalf("balances %d and %d, effective balances %d and %d", state.balances[0], state.balances[1],
+			state.validators[0].effectiveBalance, state.validators[1].effectiveBalance)
+	}
+	checkGenesisStateRoot(t, state, "0x44c4f34b77fb99404bae4fcb149f1de587455f8d13f054f80b5c93cffc4fec99")
+}
@@ -3,8 +3,12 @@ module github.com/m8b-dev/spike-deposit-2-genesis
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/ethereum/go-ethereum v1.10.25
+	github.com/golang/snappy v0.0.4
 	github.com/schollz/progressbar/v3 v3.11.0
+	github.com/supranational/blst v0.3.16
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -21,6 +25,7 @@ require (
 	github.com/rivo/uniseg v0.4.2 // indirect
 	github.com/rjeczalik/notify v0.9.1 // indirect
 	github.com/shirou/gopsutil v3.21.4-0.20210419000835-c7a38de76ee5+incompatible // indirect
+	github.com/syndtr/goleveldb v1.0.1-0.20210819022825-2ae1ddf74ef7 // indirect
 	github.com/tklauser/go-sysconf v0.3.5 // indirect
 	github.com/tklauser/numcpus v0.2.2 // indirect
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
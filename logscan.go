@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"github.com/schollz/progressbar/v3"
+	"sort"
+	"strings"
+)
+
+type blockRange struct {
+	from uint64
+	to   uint64 // inclusive
+}
+
+// logScanFetch pulls DepositEvent logs over eth_getLogs instead of walking every block. Providers cap the size
+// of a single query, so a range rejected for its size is split in halves until it goes through. Other failures are
+// retried on the same range by policy.
+// Output covers blocks [from, to) like multiThreadedFetch, but only blocks holding deposits are present. Ranges
+// complete in block order, each one is reported to cp when given. A range that still fails after the attempts of
+// policy stops the scan. Calldata of deposit transactions is fetched through f when it wants it.
+func logScanFetch(ctx context.Context, f *fetcher, deposits *binding.Binding, from, to uint64, policy retryPolicy, cp *checkpointer) ([]fetchBlockOutput, error) {
+	if to <= from {
+		return []fetchBlockOutput{}, nil
+	}
+	bar := progressbar.Default(int64(to-from), "scanning logs...")
+	defer func() {
+		_ = bar.Finish()
+		_ = bar.Close()
+	}()
+
+	output := make([]fetchBlockOutput, 0)
+	// stack of pending ranges, lowest range on top so results come in block order
+	pending := []blockRange{{from: from, to: to - 1}}
+	for len(pending) > 0 {
+		rng := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		var found []*binding.BindingDepositEvent
+		tooLarge := false
+		err := policy.do(ctx, fmt.Sprintf("deposit logs of blocks %d..%d", rng.from, rng.to), func(ctx context.Context) error {
+			var err error
+			found, err = filterDepositEvents(ctx, deposits, rng)
+			// a range turned down for its size is split, not retried. Single block can't be split further.
+			if err != nil && rng.from < rng.to && isRangeTooLarge(err) {
+				tooLarge = true
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if tooLarge {
+			mid := rng.from + (rng.to-rng.from)/2
+			pending = append(pending, blockRange{from: mid + 1, to: rng.to}, blockRange{from: rng.from, to: mid})
+			continue
+		}
+		// ranges never split a block, so grouping range by range gives the same result as all at once
+		grouped, err := groupDepositEvents(ctx, f, found)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, grouped...)
+		if cp != nil {
+			cp.progress(rng.to+1, grouped)
+		}
+		// dont let ui break the process
+		_ = bar.Add64(int64(rng.to - rng.from + 1))
+	}
+	return output, nil
+}
+
+// isRangeTooLarge tells err is the provider turning down a log query for the size of its block range or result.
+// -32005 is the limit exceeded code, some providers use it for rate limits too.
+func isRangeTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32005 && !strings.Contains(msg, "rate") {
+		return true
+	}
+	for _, sign := range []string{"query returned more than", "block range", "range is too large", "range too large", "response size"} {
+		if strings.Contains(msg, sign) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterDepositEvents(ctx context.Context, deposits *binding.Binding, rng blockRange) ([]*binding.BindingDepositEvent, error) {
+	end := rng.to
+	it, err := deposits.FilterDepositEvent(&bind.FilterOpts{Start: rng.from, End: &end, Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	found := make([]*binding.BindingDepositEvent, 0)
+	for it.Next() {
+		if it.Event.Raw.Removed {
+			continue
+		}
+		found = append(found, it.Event)
+	}
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	return found, nil
+}
+
+// groupDepositEvents shapes logs into the per block, per transaction layout produced by fetchBlock. Logs don't
+// carry recipient and input of the transaction, those are fetched separately when f wants calldata.
+func groupDepositEvents(ctx context.Context, f *fetcher, events []*binding.BindingDepositEvent) ([]fetchBlockOutput, error) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Raw.BlockNumber != events[j].Raw.BlockNumber {
+			return events[i].Raw.BlockNumber < events[j].Raw.BlockNumber
+		}
+		return events[i].Raw.Index < events[j].Raw.Index
+	})
+
+	output := make([]fetchBlockOutput, 0)
+	for _, evnt := range events {
+		raw := evnt.Raw
+		if len(output) == 0 || output[len(output)-1].block != raw.BlockNumber {
+			output = append(output, fetchBlockOutput{block: raw.BlockNumber, data: make([]fetchBlockEntry, 0)})
+		}
+		blk := &output[len(output)-1]
+		if len(blk.data) == 0 || blk.data[len(blk.data)-1].hash != raw.TxHash {
+			entry := fetchBlockEntry{hash: raw.TxHash, logs: make([]*types.Log, 0)}
+			if f.calldata {
+				var err error
+				entry.to, entry.input, err = f.fetchCalldata(ctx, raw.TxHash)
+				if err != nil {
+					return nil, err
+				}
+			}
+			blk.data = append(blk.data, entry)
+		}
+		entry := &blk.data[len(blk.data)-1]
+		entry.logs = append(entry.logs, &raw)
+	}
+	return output, nil
+}
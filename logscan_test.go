@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+// TestLogScanSplit scans 16 blocks holding 4 deposits. Ranges turned down for their size are split, rate limits and
+// other failures are retried on the same range.
+func TestLogScanSplit(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	data := loadDepositData(t)
+	logs := make([]*types.Log, 0)
+	for i, block := range []uint64{1, 5, 9, 14} {
+		logs = append(logs, depositEventLog(t, contract, data[i], uint64(i), block, common.BigToHash(big.NewInt(int64(block))), 0))
+	}
+
+	for _, tc := range []struct {
+		name string
+		// maxRange is the largest range served, failures are answered to the first calls
+		maxRange uint64
+		failures []error
+		// calls is the number of eth_getLogs calls, ranges the ranges served
+		calls  int
+		ranges int
+		fails  bool
+	}{
+		{name: "one range", maxRange: 16, calls: 1, ranges: 1},
+		{name: "split", maxRange: 4, calls: 7, ranges: 4},
+		{name: "rate limited", maxRange: 16, failures: []error{stubError{-32005, "daily request count exceeded, request rate limited"}}, calls: 2, ranges: 1},
+		{name: "response size", maxRange: 16, failures: []error{stubError{-32602, "Log response size exceeded"}}, calls: 3, ranges: 2},
+		{name: "auth", maxRange: 16, failures: []error{errors.New("invalid project id"), errors.New("invalid project id")}, calls: 2, fails: true},
+	} {
+		mut := sync.Mutex{}
+		failures := tc.failures
+		ranges := 0
+		stub, srv := newRPCStub(t, map[string]rpcHandler{
+			"eth_getLogs": func(params []json.RawMessage) (interface{}, error) {
+				var filter struct {
+					FromBlock hexutil.Uint64 `json:"fromBlock"`
+					ToBlock   hexutil.Uint64 `json:"toBlock"`
+				}
+				err := json.Unmarshal(params[0], &filter)
+				if err != nil {
+					return nil, err
+				}
+				mut.Lock()
+				defer mut.Unlock()
+				if len(failures) > 0 {
+					err, failures = failures[0], failures[1:]
+					return nil, err
+				}
+				if uint64(filter.ToBlock-filter.FromBlock) >= tc.maxRange {
+					return nil, stubError{-32005, "query returned more than 10000 results"}
+				}
+				ranges++
+				found := make([]*types.Log, 0)
+				for _, l := range logs {
+					if l.BlockNumber >= uint64(filter.FromBlock) && l.BlockNumber <= uint64(filter.ToBlock) {
+						found = append(found, l)
+					}
+				}
+				return found, nil
+			},
+		})
+		client, err := rpc.Dial(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deposits, err := binding.NewBinding(contract, ethclient.NewClient(client))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy := testPolicy()
+		policy.attempts = 2
+		got, err := logScanFetch(context.Background(), newFetcher(client, policy, 0), deposits, 0, 16, policy, nil)
+		client.Close()
+		if tc.fails != (err != nil) {
+			t.Fatalf("%s: got error %v", tc.name, err)
+		}
+		if calls := stub.count("eth_getLogs"); calls != tc.calls || ranges != tc.ranges {
+			t.Errorf("%s: %d calls and %d ranges served, want %d and %d", tc.name, calls, ranges, tc.calls, tc.ranges)
+		}
+		if tc.fails {
+			continue
+		}
+		if len(got) != len(logs) {
+			t.Fatalf("%s: got %d blocks with deposits, want %d", tc.name, len(got), len(logs))
+		}
+		for i, blk := range got {
+			if blk.block != logs[i].BlockNumber || len(blk.data) != 1 || blk.data[0].hash != logs[i].TxHash {
+				t.Errorf("%s: got block %d with %d txs at %d", tc.name, blk.block, len(blk.data), i)
+			}
+		}
+	}
+}
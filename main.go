@@ -2,19 +2,23 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
 	"github.com/schollz/progressbar/v3"
 	"math/big"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -24,135 +28,494 @@ type JSONData struct {
 	Amount                uint64 `json:"amount"`
 	Signature             string `json:"signature"`
 	DepositDataRoot       string `json:"deposit_data_root"`
+	// Valid tells whether consensus layer processes the deposit, see markValidDeposits
+	Valid bool `json:"valid"`
 }
 
-const address = "0x00000000219ab540356cbb839cbe05303d7705fa"
-const infuraUrl = ""
-const startBlk = uint64(12775113)
-const endBlk = uint64(12975113)
-const findEndBlock = false
+func (d JSONData) decode() (pubkey [48]byte, withdrawalCredentials [32]byte, signature [96]byte, err error) {
+	for _, field := range []struct {
+		name string
+		hex  string
+		out  []byte
+	}{
+		{"pubkey", d.Pubkey, pubkey[:]},
+		{"withdrawal_credentials", d.WithdrawalCredentials, withdrawalCredentials[:]},
+		{"signature", d.Signature, signature[:]},
+	} {
+		raw, err := hexutil.Decode(field.hex)
+		if err != nil {
+			return pubkey, withdrawalCredentials, signature, fmt.Errorf("invalid %s: %w", field.name, err)
+		}
+		if len(raw) != len(field.out) {
+			return pubkey, withdrawalCredentials, signature, fmt.Errorf("invalid %s length %d", field.name, len(raw))
+		}
+		copy(field.out, raw)
+	}
+	return pubkey, withdrawalCredentials, signature, nil
+}
 
 func main() {
-	eth, err := ethclient.Dial(infuraUrl)
-	if err != nil {
-		panic(err)
+	opts, err := parseOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	output := make([]JSONData, 0)
-	addr := common.HexToAddress(address)
-	deposits, err := binding.NewBinding(addr, eth)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		os.Exit(2)
 	}
-	abi, err := binding.BindingMetaData.GetAbi()
+	err = run(opts)
 	if err != nil {
-		panic(err)
+		// errors of the http transport carry the full URL along with the API key
+		fmt.Println(opts.redact(err.Error()))
+		os.Exit(1)
 	}
-	maxBlk := endBlk
-	if findEndBlock {
-		maxBlk, err = eth.BlockNumber(context.Background())
+}
+
+func run(opts *options) error {
+	// first interrupt stops the scan and saves progress, a second one kills the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	policy := opts.retryPolicy()
+
+	var cfg *chainConfig
+	var err error
+	if opts.genesis {
+		cfg, err = opts.loadChainConfig()
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
 
-	blockData := multiThreadedFetch(eth, startBlk, maxBlk, addr)
-	for _, blk := range blockData {
-		for _, txData := range blk.data {
-			data := txData.input
-			// cut off first 4 bytes of method identifier
-			data = data[4:]
-			params, err := abi.Methods["deposit"].Inputs.Unpack(data)
-			if err != nil {
-				panic(err)
-			}
-			dataRoot, ok := params[3].([32]byte)
-			if !ok {
-				panic("got invalid type for data root")
-			}
+	if opts.chainFiles != "" {
+		return runOffline(ctx, opts, cfg)
+	}
 
-			var depEvent *binding.BindingDepositEvent
-			for _, evnt := range txData.logs {
-				depEvent, err = deposits.ParseDepositEvent(*evnt)
-				if err == nil {
-					break
-				}
-			}
-			if err != nil {
-				panic(err)
-			}
-			//  solc: bytes memory amount = to_little_endian_64(uint64(deposit_amount));
+	fmt.Printf("using RPC %s\n", opts.redactedRPC())
+	endpoints, err := parseEndpoints(opts.rpcURL)
+	if err != nil {
+		return err
+	}
+	// a single endpoint is dialed directly, which also allows websocket and IPC, unless its requests are capped
+	var pool *rpcPool
+	var rpcClient *rpc.Client
+	if len(endpoints) > 1 || endpoints[0].concurrency > 0 {
+		pool, err = newRPCPool(endpoints)
+		if err != nil {
+			return err
+		}
+		rpcClient, err = pool.dial()
+	} else {
+		rpcClient, err = dialRPC(endpoints[0].url)
+	}
+	if err != nil {
+		return err
+	}
+	eth := ethclient.NewClient(rpcClient)
+	// cache keys and checkpoints carry the chain ID, chains may share the deposit contract address
+	var chainID *big.Int
+	err = policy.do(ctx, "chain id", func(ctx context.Context) error {
+		chainID, err = eth.ChainID(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if opts.net != nil && opts.net.chainID != 0 {
+		if chainID.Cmp(new(big.Int).SetUint64(opts.net.chainID)) != 0 {
+			return fmt.Errorf("RPC serves chain %s, network %s is chain %d", chainID, opts.net.name, opts.net.chainID)
+		}
+	}
+	addr := common.HexToAddress(opts.contract)
+	deposits, err := binding.NewBinding(addr, eth)
+	if err != nil {
+		return err
+	}
+	maxBlk := opts.toBlock
+	if opts.latest {
+		maxBlk, err = resolveEnd(ctx, rpcClient, policy, opts.head, opts.confirmations)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("scanning up to %s block %d\n", opts.head, maxBlk-1)
+	}
+	if !opts.toTime.IsZero() {
+		end, err := firstBlockAt(ctx, rpcClient, policy, opts.toTime.unix(), maxBlk)
+		if err != nil {
+			return err
+		}
+		if end == maxBlk {
+			return fmt.Errorf("block %d is before --to-time %s, later blocks are not there yet", maxBlk-1, opts.toTime.String())
+		}
+		maxBlk = end
+		fmt.Printf("scanning up to block %d, the last one before %s\n", maxBlk-1, opts.toTime.String())
+	}
+	if !opts.fromTime.IsZero() {
+		opts.fromBlock, err = firstBlockAt(ctx, rpcClient, policy, opts.fromTime.unix(), maxBlk)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("scanning from block %d, the first one at or after %s\n", opts.fromBlock, opts.fromTime.String())
+	}
+	if opts.indexFromChain && opts.fromBlock > 0 {
+		opts.firstIndex, err = depositCountAt(ctx, deposits, policy, opts.fromBlock-1)
+		if err != nil {
+			return err
+		}
+		if opts.untilDepositCount > 0 && opts.untilDepositCount <= opts.firstIndex {
+			return fmt.Errorf("--until-deposit-count %d ends before deposit %d, the first one after %s",
+				opts.untilDepositCount, opts.firstIndex, opts.fromTime.String())
+		}
+		fmt.Printf("first deposit of the scan has index %d\n", opts.firstIndex)
+	}
+	if opts.discoverFrom {
+		opts.fromBlock, err = findDeployBlock(ctx, eth, deposits, policy, addr, maxBlk)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deposit contract deployed in block %d\n", opts.fromBlock)
+	}
+	if opts.untilDepositCount > 0 {
+		if maxBlk <= opts.fromBlock {
+			return fmt.Errorf("empty block range %d..%d", opts.fromBlock, maxBlk)
+		}
+		last, ok, err := depositCountBlock(ctx, deposits, policy, opts.fromBlock, maxBlk-1, opts.untilDepositCount)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("contract holds less than %d deposits at block %d", opts.untilDepositCount, maxBlk-1)
+		}
+		maxBlk = last + 1
+		fmt.Printf("deposit %d is in block %d, scanning up to it\n", opts.untilDepositCount-1, last)
+	}
+	// scan goes up to where the contract holds enough deposits for genesis, more is scanned later if needed
+	var firstCandidate, lastCandidate uint64
+	if opts.genesisCandidate {
+		firstCandidate, lastCandidate, err = candidateRange(ctx, rpcClient, policy, cfg, maxBlk, time.Now())
+		if err != nil {
+			return err
+		}
+		// no deposits before the scan start, nor a contract to ask before deployment
+		from := firstCandidate
+		if from < opts.fromBlock && opts.fromBlock <= lastCandidate {
+			from = opts.fromBlock
+		}
+		// genesis can't trigger before that many deposits, every active validator takes one
+		enough, ok, err := depositCountBlock(ctx, deposits, policy, from, lastCandidate, cfg.MinGenesisActiveValidatorCount)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("genesis not triggered by block %d, the last candidate, contract holds less than MIN_GENESIS_ACTIVE_VALIDATOR_COUNT %d deposits",
+				lastCandidate, cfg.MinGenesisActiveValidatorCount)
+		}
+		fmt.Printf("genesis candidates are blocks %d..%d, contract holds %d deposits from block %d on\n",
+			firstCandidate, lastCandidate, cfg.MinGenesisActiveValidatorCount, enough)
+		maxBlk = enough + 1
+	}
 
-			output = append(output, JSONData{
-				Pubkey:                hexutil.Encode(depEvent.Pubkey),
-				WithdrawalCredentials: hexutil.Encode(depEvent.WithdrawalCredentials),
-				Amount:                binary.LittleEndian.Uint64(depEvent.Amount),
-				Signature:             hexutil.Encode(depEvent.Signature),
-				DepositDataRoot:       hexutil.Encode(dataRoot[:]),
-			})
+	var cache *blockCache
+	if opts.cache != "" && !opts.scanLogs {
+		cache, err = openBlockCache(opts.cache, chainID.Uint64())
+		if err != nil {
+			return err
 		}
+		cache.redact = opts.redact
+		defer cache.close()
 	}
 
-	outputMarshaled, err := json.Marshal(output)
+	// blocks done by an earlier run come from the checkpoint, the scan picks up where it ended
+	var cp *checkpointer
+	var blockData []fetchBlockOutput
+	scanFrom := opts.fromBlock
+	if opts.resume {
+		cp, err = loadCheckpointer(opts.checkpoint, chainID.Uint64(), addr, opts.fromBlock)
+		if err != nil {
+			return err
+		}
+		blockData = cp.blocks()
+		scanFrom = cp.next()
+		if scanFrom > maxBlk {
+			return fmt.Errorf("checkpoint is at block %d, past end block %d", scanFrom, maxBlk)
+		}
+		fmt.Printf("resuming scan at block %d, %d blocks with deposits in checkpoint\n", scanFrom, len(blockData))
+	} else if opts.checkpoint != "" {
+		cp = newCheckpointer(opts.checkpoint, chainID.Uint64(), addr, opts.fromBlock)
+	}
+	fetchPolicy := policy
+	var adaptive *aimdController
+	if opts.adaptiveConcurrency && !opts.scanLogs {
+		adaptive = newAIMDController(opts.concurrency)
+		fetchPolicy.observe = adaptive.observe
+	}
+	f := newFetcher(rpcClient, fetchPolicy, opts.batchSize)
+	f.adaptive = adaptive
+	f.calldata = opts.crossCheckCalldata
+	f.pool, f.verifyHashes = pool, opts.verifyHashes
+	if !opts.scanLogs && scanFrom < maxBlk {
+		err = f.probeBlockReceipts(ctx, scanFrom)
+		if err != nil {
+			return err
+		}
+		if !f.blockReceipts {
+			fmt.Println("node lacks eth_getBlockReceipts, fetching receipts tx by tx")
+		}
+	}
+	s := &scanner{opts: opts, rpc: rpcClient, eth: eth, deposits: deposits, addr: addr, policy: policy, f: f, cache: cache}
+	var fetched []fetchBlockOutput
+	if scanFrom < maxBlk {
+		fetched, err = s.scan(ctx, scanFrom, maxBlk, cp)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = errors.New("scan interrupted")
+	}
+	// progress is saved whether the scan completed or not, an interrupted one can be resumed
+	if cp != nil {
+		cpErr := cp.flush()
+		if cpErr != nil {
+			return fmt.Errorf("failed to write checkpoint: %w", cpErr)
+		}
+		if err != nil {
+			fmt.Printf("scan stopped at block %d, progress saved to %s, continue with --resume\n", cp.next(), opts.checkpoint)
+		}
+	}
 	if err != nil {
-		fmt.Printf("failed to marshal data, data: %+v", output)
-		panic(err)
+		return err
 	}
-	err = os.WriteFile("./deposit_data.json", outputMarshaled, 0600)
+	blockData = append(blockData, fetched...)
+	scanned, err := extractDeposits(deposits, addr, blockData, opts.crossCheckCalldata)
 	if err != nil {
-		fmt.Printf("failed to write to file, data: %+v", outputMarshaled)
-		panic(err)
+		return err
 	}
-	fmt.Printf("Scan done!\n Deposit data written OK\n Found %d deposits", len(output))
-}
+	err = orderDeposits(scanned, opts.firstIndex)
+	if err != nil {
+		return err
+	}
+	// scanned range ends right before maxBlk
+	err = verifyDepositRoot(ctx, deposits, policy, maxBlk-1, scanned, opts.firstIndex)
+	if err != nil {
+		return err
+	}
+	// root covers whole blocks, deposits past the last one wanted go only after it is checked
+	if opts.untilDepositCount > 0 && opts.firstIndex+uint64(len(scanned)) > opts.untilDepositCount {
+		kept := opts.untilDepositCount - opts.firstIndex
+		fmt.Printf("leaving out %d later deposits of block %d\n", uint64(len(scanned))-kept, maxBlk-1)
+		scanned = scanned[:kept]
+	}
+	version, err := opts.depositForkVersion(cfg)
+	if err != nil {
+		return err
+	}
+	invalid := markValidDeposits(scanned, version)
+	if invalid > 0 {
+		fmt.Printf("%d deposits with invalid signature\n", invalid)
+	}
+	if opts.genesisCandidate {
+		var block uint64
+		block, scanned, err = s.genesisBlock(ctx, cfg, scanned, firstCandidate, lastCandidate, maxBlk, version)
+		if err != nil {
+			return err
+		}
+		err = verifyDepositRoot(ctx, deposits, policy, block, scanned, opts.firstIndex)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("genesis triggers at eth1 block %d with %d deposits\n", block, len(scanned))
+		maxBlk = block + 1
+	}
+	written, err := writeDepositData(opts.output, scanned, opts.excludeInvalid)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scan done!\n Deposit data written OK\n Found %d deposits\n", written)
 
-type mutexedUint struct {
-	val uint
-	mut sync.Mutex
+	if opts.genesis {
+		return writeGenesis(ctx, rpcClient, cfg, maxBlk-1, scanned, opts.executionHeader, opts.genesisOutput)
+	}
+	if opts.follow {
+		return follow(ctx, s, scanned, maxBlk, version)
+	}
+	return nil
 }
 
-func (m *mutexedUint) Add(x uint) {
-	m.mut.Lock()
-	m.val += x
-	m.mut.Unlock()
-}
-func (m *mutexedUint) Sub(x uint) {
-	m.mut.Lock()
-	m.val -= x
-	m.mut.Unlock()
-}
-func (m *mutexedUint) Get() uint {
-	m.mut.Lock()
-	x := m.val
-	m.mut.Unlock()
-	return x
+// writeDepositData writes deposit data of scanned to path, through a temporary file so readers never see a half
+// written one. It returns how many deposits went in.
+func writeDepositData(path string, scanned []scannedDeposit, excludeInvalid bool) (int, error) {
+	output := make([]JSONData, 0, len(scanned))
+	for _, dep := range scanned {
+		if excludeInvalid && !dep.data.Valid {
+			continue
+		}
+		output = append(output, dep.data)
+	}
+	outputMarshaled, err := json.Marshal(output)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, outputMarshaled, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write deposit data: %w", err)
+	}
+	return len(output), nil
 }
 
-func multiThreadedFetch(client *ethclient.Client, from, to uint64, filter common.Address) []fetchBlockOutput {
-	const maxThreads = 80
+// multiThreadedFetch fetches blocks [from, to) with a pool of workers, through cache when given. Each worker takes
+// f.jobSize() blocks at a time. Finished blocks are reported to cp, when given, as soon as all blocks before them are
+// done. The first block that can't be fetched stops the pool, so does ctx.
+func multiThreadedFetch(ctx context.Context, f *fetcher, from, to uint64, filter common.Address, workers int, cache *blockCache, cp *checkpointer) ([]fetchBlockOutput, error) {
 	runs := int(to - from)
-	activeThreads := mutexedUint{val: 0, mut: sync.Mutex{}}
-
 	bar := progressbar.Default(int64(runs), "scanning blocks...")
+	defer func() {
+		_ = bar.Finish()
+		_ = bar.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	size := f.jobSize()
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < runs; i += size {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	output := make([]fetchBlockOutput, runs)
-	for i := 0; i < runs; i++ {
-		for activeThreads.Get() >= maxThreads {
-			time.Sleep(time.Millisecond)
+	// blocks before next are done
+	done := make([]bool, runs)
+	next := 0
+	shownLimit := 0
+	var firstErr error
+	mut := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range jobs {
+				if f.adaptive.acquire(ctx) != nil {
+					return
+				}
+				end := start + size
+				if end > runs {
+					end = runs
+				}
+				blocks := make([]uint64, 0, end-start)
+				for i := start; i < end; i++ {
+					blocks = append(blocks, from+uint64(i))
+				}
+				blks, err := cache.fetchBlocks(ctx, f, blocks, filter)
+				f.adaptive.release()
+				mut.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					mut.Unlock()
+					cancel()
+					return
+				}
+				copy(output[start:end], blks)
+				for i := start; i < end; i++ {
+					done[i] = true
+				}
+				reported := next
+				for next < runs && done[next] {
+					next++
+				}
+				if cp != nil && next > reported {
+					cp.progress(from+uint64(next), output[reported:next])
+				}
+				if f.adaptive != nil && f.adaptive.current() != shownLimit {
+					shownLimit = f.adaptive.current()
+					bar.Describe(fmt.Sprintf("scanning blocks, concurrency %d...", shownLimit))
+				}
+				mut.Unlock()
+				// dont let ui break the process
+				_ = bar.Add(end - start)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// workers also stop once jobs run dry, which happens early when ctx is done
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return output, nil
+}
+
+// fetcher fetches blocks from the node, either block by block or in JSON-RPC batches when batch is set
+type fetcher struct {
+	rpc    *rpc.Client
+	eth    *ethclient.Client
+	policy retryPolicy
+	batch  *batcher
+	// blockReceipts tells receipts can be taken a block at a time, see probeBlockReceipts
+	blockReceipts bool
+	// calldata fetches recipient and input of deposit transactions, only calldata cross-check needs them
+	calldata bool
+	// pool is set when rpc spreads calls over several endpoints, verifyHashes then has two of them agree on
+	// fetched blocks
+	pool         *rpcPool
+	verifyHashes bool
+	// adaptive keeps jobs in flight under a limit it adjusts, workers are its maximum
+	adaptive *aimdController
+}
+
+func newFetcher(client *rpc.Client, policy retryPolicy, batchSize int) *fetcher {
+	f := &fetcher{rpc: client, eth: ethclient.NewClient(client), policy: policy}
+	if batchSize > 0 {
+		f.batch = newBatcher(client, policy, batchSize)
+	}
+	return f
+}
+
+// jobSize is how many blocks a worker takes at once, a batch worth of them when batching
+func (f *fetcher) jobSize() int {
+	if f.batch == nil {
+		return 1
+	}
+	return f.batch.batchSize()
+}
+
+func (f *fetcher) fetchBlocks(ctx context.Context, blocks []uint64, filter common.Address) ([]fetchBlockOutput, error) {
+	// blocks fetched have to be the ones endpoints agreed on, zero hash skips the check
+	agreed := make([]common.Hash, len(blocks))
+	if f.verifyHashes {
+		var err error
+		agreed, err = f.checkBlockHashes(ctx, blocks)
+		if err != nil {
+			return nil, err
 		}
-		activeThreads.Add(1)
-		go func(i int) {
-			output[i] = fetchBlock(client, from+uint64(i), filter)
-			activeThreads.Sub(1)
-			// dont let ui break the process
-			_ = bar.Add(1)
-		}(i)
 	}
-	for activeThreads.Get() > 0 {
-		time.Sleep(time.Millisecond)
+	if f.batch != nil {
+		return f.fetchBlocksBatched(ctx, blocks, agreed, filter)
 	}
-	_ = bar.Finish()
-	_ = bar.Close()
-	return output
+	output := make([]fetchBlockOutput, len(blocks))
+	for i, block := range blocks {
+		var err error
+		output[i], err = f.fetchBlock(ctx, block, agreed[i], filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
 }
 
 type fetchBlockOutput struct {
@@ -160,42 +523,100 @@ type fetchBlockOutput struct {
 	data  []fetchBlockEntry
 }
 type fetchBlockEntry struct {
-	hash  common.Hash
+	hash common.Hash
+	// to and input are left empty unless calldata is cross-checked
+	to    *common.Address
 	input []byte
 	logs  []*types.Log
 }
 
-func fetchBlock(client *ethclient.Client, block uint64, filter common.Address) fetchBlockOutput {
-	err := errors.New("fake err")
-	var blk *types.Block
-	// try until success (tmp network issues etc)
-	for err != nil {
-		blk, err = client.BlockByNumber(context.Background(), big.NewInt(0).SetUint64(block))
-	}
-	txns := make([]*types.Transaction, 0)
+func (f *fetcher) fetchBlock(ctx context.Context, block uint64, agreed common.Hash, filter common.Address) (fetchBlockOutput, error) {
 	output := fetchBlockOutput{
 		block: block,
 		data:  make([]fetchBlockEntry, 0),
 	}
-	for _, txn := range blk.Transactions() {
-		if txn.To() != nil && *txn.To() == filter {
-			txns = append(txns, txn)
-		}
+	// transaction bodies stay on the node, go-ethereum can't decode types newer than it knows and receipts only
+	// need the hashes
+	var header *rpcBlockHashes
+	err := f.policy.do(ctx, fmt.Sprintf("block %d", block), func(ctx context.Context) error {
+		return f.rpc.CallContext(ctx, &header, "eth_getBlockByNumber", hexutil.EncodeUint64(block), false)
+	})
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	if header == nil || uint64(header.Number) != block {
+		return fetchBlockOutput{}, fmt.Errorf("block %d not found", block)
+	}
+	err = checkAgreedBlock(block, agreed, header.Hash, nil)
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	// deposits may be routed through other contracts, so the tx recipient tells nothing. Bloom rules out
+	// blocks without a deposit log, otherwise every receipt in block has to be checked.
+	if !mayHoldDeposits(header.LogsBloom, filter) {
+		return output, nil
 	}
-	for _, txn := range txns {
-		err = errors.New("fake err")
-		var rcpt *types.Receipt
-		// try until success (tmp network issues etc)
-		for err != nil {
-			rcpt, err = client.TransactionReceipt(context.Background(), txn.Hash())
+	receipts, err := f.fetchReceipts(ctx, block, header.Transactions)
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	err = checkAgreedBlock(block, agreed, header.Hash, receipts)
+	if err != nil {
+		return fetchBlockOutput{}, err
+	}
+	for i, hash := range header.Transactions {
+		rcpt := receipts[i]
+		if rcpt.Status != 1 || !hasLogFrom(rcpt.Logs, filter) {
+			continue
+		}
+		entry := fetchBlockEntry{hash: hash, logs: rcpt.Logs}
+		if f.calldata {
+			entry.to, entry.input, err = f.fetchCalldata(ctx, hash)
+			if err != nil {
+				return fetchBlockOutput{}, err
+			}
 		}
-		if rcpt.Status == 1 {
-			output.data = append(output.data, fetchBlockEntry{
-				hash:  txn.Hash(),
-				input: txn.Data(),
-				logs:  rcpt.Logs,
-			})
+		output.data = append(output.data, entry)
+	}
+	return output, nil
+}
+
+// rpcTxCalldata is the part of a transaction as returned by eth_getTransactionByHash that calldata cross-check
+// needs. Decoding the whole of it fails on transaction types newer than go-ethereum.
+type rpcTxCalldata struct {
+	To    *common.Address `json:"to"`
+	Input hexutil.Bytes   `json:"input"`
+}
+
+// fetchCalldata returns recipient and input of tx hash
+func (f *fetcher) fetchCalldata(ctx context.Context, hash common.Hash) (*common.Address, []byte, error) {
+	var txn *rpcTxCalldata
+	err := f.policy.do(ctx, "tx "+hash.Hex(), func(ctx context.Context) error {
+		return f.rpc.CallContext(ctx, &txn, "eth_getTransactionByHash", hash)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if txn == nil {
+		return nil, nil, fmt.Errorf("tx %s not found", hash.Hex())
+	}
+	return txn.To, txn.Input, nil
+}
+
+// depositEventTopic is topic 0 of DepositEvent logs
+var depositEventTopic = crypto.Keccak256Hash([]byte("DepositEvent(bytes,bytes,bytes,bytes,bytes)"))
+
+// mayHoldDeposits tells by logs bloom of a block whether it can hold a DepositEvent of contract. Bloom gives false
+// positives, never false negatives.
+func mayHoldDeposits(bloom types.Bloom, contract common.Address) bool {
+	return bloom.Test(contract.Bytes()) && bloom.Test(depositEventTopic.Bytes())
+}
+
+func hasLogFrom(logs []*types.Log, addr common.Address) bool {
+	for _, l := range logs {
+		if l.Address == addr {
+			return true
 		}
 	}
-	return output
+	return false
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rpcHandler answers a JSON-RPC call with its params
+type rpcHandler func(params []json.RawMessage) (interface{}, error)
+
+// stubError is a JSON-RPC error with its code, handlers return it to answer with a code other than -32000
+type stubError struct {
+	code int
+	msg  string
+}
+
+func (e stubError) Error() string  { return e.msg }
+func (e stubError) ErrorCode() int { return e.code }
+
+// rpcStub serves JSON-RPC over HTTP out of handlers, batches included. Methods without a handler fail as unknown.
+type rpcStub struct {
+	handlers map[string]rpcHandler
+
+	mut   sync.Mutex
+	calls map[string]int
+}
+
+func newRPCStub(t *testing.T, handlers map[string]rpcHandler) (*rpcStub, *httptest.Server) {
+	stub := &rpcStub{handlers: handlers, calls: make(map[string]int)}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+type stubRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (s *rpcStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var reqs []stubRequest
+	batch := len(raw) > 0 && raw[0] == '['
+	if batch {
+		err = json.Unmarshal(raw, &reqs)
+	} else {
+		reqs = make([]stubRequest, 1)
+		err = json.Unmarshal(raw, &reqs[0])
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resps := make([]map[string]interface{}, len(reqs))
+	for i, req := range reqs {
+		s.mut.Lock()
+		s.calls[req.Method]++
+		s.mut.Unlock()
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		handler, ok := s.handlers[req.Method]
+		if !ok {
+			resp["error"] = map[string]interface{}{"code": -32601, "message": fmt.Sprintf("the method %s does not exist", req.Method)}
+		} else if result, err := handler(req.Params); err != nil {
+			code := -32000
+			if codeErr, ok := err.(stubError); ok {
+				code = codeErr.code
+			}
+			resp["error"] = map[string]interface{}{"code": code, "message": err.Error()}
+		} else {
+			resp["result"] = result
+		}
+		resps[i] = resp
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		_ = json.NewEncoder(w).Encode(resps)
+	} else {
+		_ = json.NewEncoder(w).Encode(resps[0])
+	}
+}
+
+func (s *rpcStub) count(method string) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.calls[method]
+}
+
+// stubChain is a chain of blocks with deposits of contract, served by handlers in the eth_getBlockByNumber,
+// eth_getBlockReceipts and eth_getTransactionByHash formats. Transactions are blob (type 3) and set code (type 4)
+// ones, go-ethereum v1.10.25 can't decode those.
+type stubChain struct {
+	contract common.Address
+	blocks   []stubBlock
+}
+
+type stubBlock struct {
+	hash     common.Hash
+	receipts []*types.Receipt
+}
+
+func newStubChain(contract common.Address, blocks int) *stubChain {
+	c := &stubChain{contract: contract}
+	for number := 0; number < blocks; number++ {
+		blk := stubBlock{hash: common.BigToHash(big.NewInt(int64(1000 + number)))}
+		// odd blocks hold a deposit
+		if number%2 == 1 {
+			for i, txType := range []uint8{3, 4} {
+				txHash := common.BigToHash(big.NewInt(int64(number*10 + i)))
+				logs := []*types.Log{}
+				if txType == 3 {
+					logs = append(logs, &types.Log{Address: contract, Topics: []common.Hash{depositEventTopic}, Data: []byte{},
+						TxHash: txHash, BlockHash: blk.hash, BlockNumber: uint64(number)})
+				}
+				blk.receipts = append(blk.receipts, &types.Receipt{Type: txType, Status: 1, Logs: logs, TxHash: txHash,
+					BlockHash: blk.hash, BlockNumber: big.NewInt(int64(number)), TransactionIndex: uint(i)})
+			}
+		}
+		c.blocks = append(c.blocks, blk)
+	}
+	return c
+}
+
+func (c *stubChain) block(params []json.RawMessage) (*stubBlock, uint64, error) {
+	var number hexutil.Uint64
+	err := json.Unmarshal(params[0], &number)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint64(number) >= uint64(len(c.blocks)) {
+		return nil, 0, nil
+	}
+	return &c.blocks[number], uint64(number), nil
+}
+
+func (c *stubChain) handlers() map[string]rpcHandler {
+	return map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, error) {
+			blk, number, err := c.block(params)
+			if err != nil || blk == nil {
+				return nil, err
+			}
+			var bloom types.Bloom
+			hashes := make([]common.Hash, len(blk.receipts))
+			for i, rcpt := range blk.receipts {
+				hashes[i] = rcpt.TxHash
+				for _, l := range rcpt.Logs {
+					bloom.Add(l.Address.Bytes())
+					bloom.Add(l.Topics[0].Bytes())
+				}
+			}
+			return map[string]interface{}{"number": hexutil.Uint64(number), "hash": blk.hash, "logsBloom": bloom,
+				"transactions": hashes}, nil
+		},
+		"eth_getBlockReceipts": func(params []json.RawMessage) (interface{}, error) {
+			blk, _, err := c.block(params)
+			if err != nil || blk == nil {
+				return nil, err
+			}
+			return blk.receipts, nil
+		},
+		"eth_getTransactionByHash": func(params []json.RawMessage) (interface{}, error) {
+			var hash common.Hash
+			err := json.Unmarshal(params[0], &hash)
+			if err != nil {
+				return nil, err
+			}
+			for _, blk := range c.blocks {
+				for _, rcpt := range blk.receipts {
+					if rcpt.TxHash == hash {
+						return map[string]interface{}{"hash": hash, "type": hexutil.Uint64(rcpt.Type), "to": c.contract,
+							"input": hexutil.Bytes{0x22, 0x89, 0x51, 0x18}, "blobVersionedHashes": []common.Hash{{0x01}},
+							"authorizationList": []interface{}{}}, nil
+					}
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// depositEventLog is the DepositEvent log of deposit d made with contract index, at position logIndex of block
+func depositEventLog(t *testing.T, contract common.Address, d JSONData, index uint64, block uint64, txHash common.Hash, logIndex uint) *types.Log {
+	t.Helper()
+	contractAbi, err := binding.BindingMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := contractAbi.Events["DepositEvent"]
+	pubkey, withdrawalCredentials, signature, err := d.decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := event.Inputs.NonIndexed().Pack(pubkey[:], withdrawalCredentials[:],
+		binary.LittleEndian.AppendUint64(nil, d.Amount), signature[:], binary.LittleEndian.AppendUint64(nil, index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.Log{Address: contract, Topics: []common.Hash{event.ID}, Data: data, BlockNumber: block,
+		TxHash: txHash, Index: logIndex}
+}
+
+func testPolicy() retryPolicy {
+	return retryPolicy{attempts: 1, timeout: 5 * time.Second, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+}
+
+// TestFetchBlockNewTransactionTypes fetches blocks holding transaction types go-ethereum can't decode, with and
+// without calldata, one by one and batched
+func TestFetchBlockNewTransactionTypes(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	chain := newStubChain(contract, 4)
+	stub, srv := newRPCStub(t, chain.handlers())
+	client, err := rpc.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, tc := range []struct {
+		batchSize int
+		calldata  bool
+	}{{0, false}, {0, true}, {2, false}, {2, true}} {
+		calldata := tc.calldata
+		f := newFetcher(client, testPolicy(), tc.batchSize)
+		f.blockReceipts = true
+		f.calldata = calldata
+		before := stub.count("eth_getTransactionByHash")
+		got, err := f.fetchBlocks(context.Background(), []uint64{0, 1, 2, 3}, contract)
+		if err != nil {
+			t.Fatalf("batch %d, calldata %v: %v", tc.batchSize, calldata, err)
+		}
+		for i, blk := range got {
+			if blk.block != uint64(i) {
+				t.Fatalf("batch %d, calldata %v: block %d at %d", tc.batchSize, calldata, blk.block, i)
+			}
+			want := chain.blocks[i].receipts
+			if len(want) > 0 {
+				want = want[:1]
+			}
+			if len(blk.data) != len(want) {
+				t.Fatalf("batch %d, calldata %v: block %d has %d deposit txs, want %d", tc.batchSize, calldata, i, len(blk.data), len(want))
+			}
+			for _, entry := range blk.data {
+				if entry.hash != want[0].TxHash || len(entry.logs) != 1 {
+					t.Errorf("batch %d, calldata %v: block %d got tx %s with %d logs", tc.batchSize, calldata, i, entry.hash.Hex(), len(entry.logs))
+				}
+				if calldata != (entry.to != nil && *entry.to == contract && len(entry.input) == 4) {
+					t.Errorf("batch %d, calldata %v: block %d got to %v, input %x", tc.batchSize, calldata, i, entry.to, entry.input)
+				}
+			}
+		}
+		fetchedTxs := stub.count("eth_getTransactionByHash") - before
+		if calldata != (fetchedTxs > 0) {
+			t.Errorf("batch %d, calldata %v: %d eth_getTransactionByHash calls", tc.batchSize, calldata, fetchedTxs)
+		}
+	}
+}
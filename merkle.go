@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"math/big"
+)
+
+const depositContractTreeDepth = 32
+
+// depositTree is a port of the incremental merkle tree kept by contract.sol
+type depositTree struct {
+	branch     [depositContractTreeDepth][32]byte
+	zeroHashes [depositContractTreeDepth][32]byte
+	count      uint64
+}
+
+func newDepositTree() *depositTree {
+	t := &depositTree{}
+	for height := 0; height < depositContractTreeDepth-1; height++ {
+		t.zeroHashes[height+1] = hashPair(t.zeroHashes[height], t.zeroHashes[height])
+	}
+	return t
+}
+
+// push adds deposit_data_root of the next deposit, same as deposit() in contract.sol
+func (t *depositTree) push(node [32]byte) error {
+	if t.count >= 1<<depositContractTreeDepth-1 {
+		return errors.New("merkle tree full")
+	}
+	t.count++
+	size := t.count
+	for height := 0; height < depositContractTreeDepth; height++ {
+		if size&1 == 1 {
+			t.branch[height] = node
+			return nil
+		}
+		node = hashPair(t.branch[height], node)
+		size /= 2
+	}
+	// unreachable, loop always returns while count fits the tree
+	return errors.New("merkle tree inconsistent")
+}
+
+// root is what get_deposit_root() in contract.sol returns for the same deposits
+func (t *depositTree) root() [32]byte {
+	var node [32]byte
+	size := t.count
+	for height := 0; height < depositContractTreeDepth; height++ {
+		if size&1 == 1 {
+			node = hashPair(t.branch[height], node)
+		} else {
+			node = hashPair(node, t.zeroHashes[height])
+		}
+		size /= 2
+	}
+	return hashPair(node, uint64Chunk(t.count))
+}
+
+// depositRoot is the root of the deposit tree holding deps, which start at index 0
+func depositRoot(deps []scannedDeposit) ([32]byte, error) {
+	tree := newDepositTree()
+	for _, dep := range deps {
+		err := tree.push(dep.root)
+		if err != nil {
+			return [32]byte{}, err
+		}
+	}
+	return tree.root(), nil
+}
+
+// errDepositMismatch is scanned deposits not adding up to the contract state, as opposed to failing to get it
+var errDepositMismatch = errors.New("deposit mismatch")
+
+// verifyDepositRoot rebuilds the deposit tree out of scanned deposits and compares it with the contract state at
+// given block. Matching root proves no deposit got lost or altered. When scan doesn't start at the first deposit
+// the tree can't be rebuilt, and only the count is compared. Contract calls are retried as policy says.
+func verifyDepositRoot(ctx context.Context, deposits *binding.Binding, policy retryPolicy, block uint64, scanned []scannedDeposit, firstIndex uint64) error {
+	var countRaw []byte
+	err := policy.do(ctx, fmt.Sprintf("deposit count at block %d", block), func(ctx context.Context) error {
+		var err error
+		countRaw, err = deposits.GetDepositCount(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(block), Context: ctx})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(countRaw) != 8 {
+		return fmt.Errorf("invalid deposit count length %d", len(countRaw))
+	}
+	count := binary.LittleEndian.Uint64(countRaw)
+	if firstIndex+uint64(len(scanned)) != count {
+		return fmt.Errorf("%w: contract holds %d deposits at block %d, scanned %d starting at index %d",
+			errDepositMismatch, count, block, len(scanned), firstIndex)
+	}
+	if firstIndex != 0 {
+		fmt.Printf("scan starts at deposit %d, only deposit count verified\n", firstIndex)
+		return nil
+	}
+
+	root, err := depositRoot(scanned)
+	if err != nil {
+		return err
+	}
+	var contractRoot [32]byte
+	err = policy.do(ctx, fmt.Sprintf("deposit root at block %d", block), func(ctx context.Context) error {
+		var err error
+		contractRoot, err = deposits.GetDepositRoot(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(block), Context: ctx})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if root != contractRoot {
+		return fmt.Errorf("%w at block %d: contract deposit root %s, scanned %s",
+			errDepositMismatch, block, hexutil.Encode(contractRoot[:]), hexutil.Encode(root[:]))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"testing"
+)
+
+// emptyDepositRoot is what get_deposit_root() of a fresh deposit contract returns
+const emptyDepositRoot = "0xd70a234731285c6804c2a4f56711ddb8c82c99740f207854891028af34e27e5e"
+
+func TestDepositTreeEmptyRoot(t *testing.T) {
+	root := newDepositTree().root()
+	if got := hexutil.Encode(root[:]); got != emptyDepositRoot {
+		t.Fatalf("empty root %s, want %s", got, emptyDepositRoot)
+	}
+	if want := mixInLength(merkleizeLimit(nil, 1<<depositContractTreeDepth), 0); root != want {
+		t.Fatalf("empty root %s, want %s", hexutil.Encode(root[:]), hexutil.Encode(want[:]))
+	}
+}
+
+// TestDepositTreeRoot checks the incremental tree against hash_tree_root(List[DepositData, 2**32]) of the spec
+func TestDepositTreeRoot(t *testing.T) {
+	data := loadDepositData(t)
+	roots := make([][32]byte, 0, len(data))
+	for _, d := range data {
+		raw, err := hexutil.Decode(d.DepositDataRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var root [32]byte
+		copy(root[:], raw)
+		roots = append(roots, root)
+	}
+	tree := newDepositTree()
+	for i, root := range roots {
+		err := tree.push(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := uint64(i + 1)
+		want := mixInLength(merkleizeLimit(roots[:n], 1<<depositContractTreeDepth), n)
+		if got := tree.root(); got != want {
+			t.Fatalf("root with %d deposits %s, want %s", n, hexutil.Encode(got[:]), hexutil.Encode(want[:]))
+		}
+	}
+}
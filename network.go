@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// network carries everything known upfront about a chain, so scans and genesis builds need only its name
+type network struct {
+	name            string
+	chainID         uint64
+	depositContract common.Address
+	deployBlock     uint64
+	config          chainConfig
+}
+
+var networks = map[string]*network{
+	"mainnet": {
+		name:            "mainnet",
+		chainID:         1,
+		depositContract: common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa"),
+		deployBlock:     11052984,
+		config: chainConfig{
+			PresetBase:                     "mainnet",
+			MinGenesisActiveValidatorCount: 16384,
+			MinGenesisTime:                 1606824000,
+			GenesisForkVersion:             forkVersion{0x00, 0x00, 0x00, 0x00},
+			GenesisDelay:                   604800,
+			DepositChainID:                 1,
+			SecondsPerEth1Block:            14,
+			Eth1FollowDistance:             2048,
+			AltairForkVersion:              forkVersion{0x01, 0x00, 0x00, 0x00},
+			AltairForkEpoch:                74240,
+			BellatrixForkVersion:           forkVersion{0x02, 0x00, 0x00, 0x00},
+			BellatrixForkEpoch:             144896,
+			CapellaForkVersion:             forkVersion{0x03, 0x00, 0x00, 0x00},
+			CapellaForkEpoch:               194048,
+			DenebForkVersion:               forkVersion{0x04, 0x00, 0x00, 0x00},
+			DenebForkEpoch:                 269568,
+			ElectraForkVersion:             forkVersion{0x05, 0x00, 0x00, 0x00},
+			ElectraForkEpoch:               364032,
+		},
+	},
+	"sepolia": {
+		name:            "sepolia",
+		chainID:         11155111,
+		depositContract: common.HexToAddress("0x7f02C3E3c98b133055B8B348B2Ac625669Ed295D"),
+		deployBlock:     1273020,
+		config: chainConfig{
+			PresetBase:                     "mainnet",
+			MinGenesisActiveValidatorCount: 1300,
+			MinGenesisTime:                 1655647200,
+			GenesisForkVersion:             forkVersion{0x90, 0x00, 0x00, 0x69},
+			GenesisDelay:                   86400,
+			DepositChainID:                 11155111,
+			SecondsPerEth1Block:            14,
+			Eth1FollowDistance:             2048,
+			AltairForkVersion:              forkVersion{0x90, 0x00, 0x00, 0x70},
+			AltairForkEpoch:                50,
+			BellatrixForkVersion:           forkVersion{0x90, 0x00, 0x00, 0x71},
+			BellatrixForkEpoch:             100,
+			CapellaForkVersion:             forkVersion{0x90, 0x00, 0x00, 0x72},
+			CapellaForkEpoch:               56832,
+			DenebForkVersion:               forkVersion{0x90, 0x00, 0x00, 0x73},
+			DenebForkEpoch:                 132608,
+			ElectraForkVersion:             forkVersion{0x90, 0x00, 0x00, 0x74},
+			ElectraForkEpoch:               222464,
+		},
+	},
+	// holesky and hoodi got genesis validators through deposit contract storage of the execution genesis, the
+	// contract is there from block 0 and its log holds only later deposits
+	"holesky": {
+		name:            "holesky",
+		chainID:         17000,
+		depositContract: common.HexToAddress("0x4242424242424242424242424242424242424242"),
+		deployBlock:     0,
+		config: chainConfig{
+			PresetBase:                     "mainnet",
+			MinGenesisActiveValidatorCount: 16384,
+			MinGenesisTime:                 1695902100,
+			GenesisForkVersion:             forkVersion{0x01, 0x01, 0x70, 0x00},
+			GenesisDelay:                   300,
+			DepositChainID:                 17000,
+			SecondsPerEth1Block:            14,
+			Eth1FollowDistance:             2048,
+			AltairForkVersion:              forkVersion{0x02, 0x01, 0x70, 0x00},
+			AltairForkEpoch:                0,
+			BellatrixForkVersion:           forkVersion{0x03, 0x01, 0x70, 0x00},
+			BellatrixForkEpoch:             0,
+			CapellaForkVersion:             forkVersion{0x04, 0x01, 0x70, 0x00},
+			CapellaForkEpoch:               256,
+			DenebForkVersion:               forkVersion{0x05, 0x01, 0x70, 0x00},
+			DenebForkEpoch:                 29696,
+			ElectraForkVersion:             forkVersion{0x06, 0x01, 0x70, 0x00},
+			ElectraForkEpoch:               115968,
+		},
+	},
+	"hoodi": {
+		name:            "hoodi",
+		chainID:         560048,
+		depositContract: common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa"),
+		deployBlock:     0,
+		config: chainConfig{
+			PresetBase:                     "mainnet",
+			MinGenesisActiveValidatorCount: 16384,
+			MinGenesisTime:                 1742212800,
+			GenesisForkVersion:             forkVersion{0x10, 0x00, 0x09, 0x10},
+			GenesisDelay:                   600,
+			DepositChainID:                 560048,
+			SecondsPerEth1Block:            12,
+			Eth1FollowDistance:             2048,
+			AltairForkVersion:              forkVersion{0x20, 0x00, 0x09, 0x10},
+			AltairForkEpoch:                0,
+			BellatrixForkVersion:           forkVersion{0x30, 0x00, 0x09, 0x10},
+			BellatrixForkEpoch:             0,
+			CapellaForkVersion:             forkVersion{0x40, 0x00, 0x09, 0x10},
+			CapellaForkEpoch:               0,
+			DenebForkVersion:               forkVersion{0x50, 0x00, 0x09, 0x10},
+			DenebForkEpoch:                 0,
+			ElectraForkVersion:             forkVersion{0x60, 0x00, 0x09, 0x10},
+			ElectraForkEpoch:               2048,
+		},
+	},
+}
+
+// loadNetwork returns the preset of given name, anything else is taken for a testnet directory holding config.yaml
+// and deposit_contract_block.txt
+func loadNetwork(nameOrDir string) (*network, error) {
+	if net, ok := networks[nameOrDir]; ok {
+		return net, nil
+	}
+	info, err := os.Stat(nameOrDir)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("unknown network %q, want one of mainnet, sepolia, holesky, hoodi or a testnet directory", nameOrDir)
+	}
+	cfg, err := loadChainConfig(filepath.Join(nameOrDir, "config.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if !common.IsHexAddress(cfg.DepositContractAddress) {
+		return nil, fmt.Errorf("invalid DEPOSIT_CONTRACT_ADDRESS %q in %s", cfg.DepositContractAddress, nameOrDir)
+	}
+	blockPath := filepath.Join(nameOrDir, "deposit_contract_block.txt")
+	raw, err := os.ReadFile(blockPath)
+	if err != nil {
+		return nil, err
+	}
+	deployBlock, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment block in %s: %w", blockPath, err)
+	}
+	return &network{
+		name:            filepath.Base(nameOrDir),
+		chainID:         cfg.DepositChainID,
+		depositContract: common.HexToAddress(cfg.DepositContractAddress),
+		deployBlock:     deployBlock,
+		config:          *cfg,
+	}, nil
+}
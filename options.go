@@ -0,0 +1,513 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix prefixes environment variables overriding flags, D2G_RPC sets --rpc and alike
+const envPrefix = "D2G_"
+
+// options are settings of a run. Each one comes from, in increasing priority: default, config file, environment
+// variable, command line flag.
+type options struct {
+	// genesis command additionally builds genesis state out of scanned deposits
+	genesis bool
+	// follow command keeps scanning new blocks after the first scan, every followInterval
+	follow         bool
+	followInterval time.Duration
+
+	// network preset or testnet directory, provides defaults of contract, block range, fork version and chain config
+	network string
+	rpcURL  string
+	// chainFiles are geth export files or Era1 archives blocks are read from instead of RPC
+	chainFiles string
+	contract   string
+	fromBlock  uint64
+	// discoverFrom looks the deployment block of the contract up and scans from there, set without --from-block
+	discoverFrom bool
+	toBlock      uint64
+	latest       bool
+	// fromTime and toTime bound the scan by block timestamps instead of numbers
+	fromTime timeFlag
+	toTime   timeFlag
+	// untilDepositCount ends the scan right after deposit untilDepositCount-1, even in the middle of a block
+	untilDepositCount uint64
+	// head is the block tag a scan up to the head stops at, less confirmations
+	head          string
+	confirmations uint64
+	output        string
+	concurrency   int
+	// adaptiveConcurrency tunes concurrency to the provider, concurrency is then the upper bound
+	adaptiveConcurrency bool
+	// rpcTimeout bounds a single RPC call, retries bounds attempts of a call before the scan gives up
+	rpcTimeout time.Duration
+	retries    int
+	// batchSize is how many blocks go into one JSON-RPC batch, 0 fetches them with separate calls
+	batchSize int
+	// verifyHashes has two RPC endpoints agree on the hash of every fetched block
+	verifyHashes bool
+	// scanLogs fetches deposits through eth_getLogs instead of downloading every block in range
+	scanLogs bool
+	// crossCheckCalldata compares computed deposit_data_root with the one passed in calldata of direct deposit calls
+	crossCheckCalldata bool
+	// firstIndex is the index of the first deposit expected in scanned range, 0 when scanning from deployment
+	firstIndex uint64
+	// indexFromChain takes firstIndex from the contract at the block before the scan, set with --from-time
+	// without --first-index
+	indexFromChain bool
+	// forkVersion is GENESIS_FORK_VERSION of the chain, deposit signatures are verified against it. genesis
+	// command takes the one from chain config instead.
+	forkVersion    string
+	excludeInvalid bool
+	// checkpoint is where scan progress is kept, empty disables checkpoints
+	checkpoint string
+	// cache is the directory of the block cache, empty disables it. Log scans don't use it.
+	cache string
+	// resume continues the scan from checkpoint instead of starting over
+	resume          bool
+	chainConfig     string
+	executionHeader string
+	genesisOutput   string
+	// genesisCandidate ends the scan at the eth1 block genesis triggers at, as beacon nodes pick it
+	genesisCandidate bool
+
+	net *network
+}
+
+func defaultOptions() options {
+	return options{
+		contract:       "0x00000000219ab540356cbb839cbe05303d7705fa",
+		toBlock:        12975113,
+		output:         "./deposit_data.json",
+		checkpoint:     "./scan.checkpoint.json",
+		cache:          "./cache",
+		concurrency:    80,
+		head:           "finalized",
+		followInterval: 12 * time.Second,
+		rpcTimeout:     30 * time.Second,
+		retries:        5,
+		forkVersion:    "0x00000000",
+		chainConfig:    "./config.yaml",
+		genesisOutput:  "./genesis.ssz",
+	}
+}
+
+func (o *options) flagSet() (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("spike-deposit-2-genesis", flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML or TOML file with flag values, keys are flag names")
+	fs.StringVar(&o.network, "network", o.network, "mainnet, sepolia, holesky, hoodi or a testnet directory with config.yaml and deposit_contract_block.txt")
+	fs.StringVar(&o.rpcURL, "rpc", o.rpcURL, "execution layer JSON-RPC URL, or comma separated URLs with optional #weight=n&concurrency=n")
+	fs.StringVar(&o.chainFiles, "chain-files", o.chainFiles, "comma separated Era1 archives, geth export files or directories of them to read blocks from instead of RPC, deposits need Era1 archives")
+	fs.BoolVar(&o.verifyHashes, "verify-hashes", o.verifyHashes, "check hashes of fetched blocks with a second RPC endpoint")
+	fs.StringVar(&o.contract, "contract", o.contract, "deposit contract address")
+	fs.Uint64Var(&o.fromBlock, "from-block", o.fromBlock, "first block to scan, deployment block of the contract when unset")
+	fs.Uint64Var(&o.toBlock, "to-block", o.toBlock, "block to stop scanning at, exclusive")
+	fs.Var(&o.fromTime, "from-time", "scan from the first block at or after this time, RFC 3339, UTC date and time or unix seconds")
+	fs.Var(&o.toTime, "to-time", "scan blocks before this time, see --from-time")
+	fs.Uint64Var(&o.untilDepositCount, "until-deposit-count", o.untilDepositCount, "stop the scan after that many deposits")
+	fs.BoolVar(&o.latest, "latest", o.latest, "scan up to the chain head, see --head, instead of --to-block")
+	fs.StringVar(&o.head, "head", o.head, "block a scan up to the chain head ends at: finalized, safe or latest")
+	fs.Uint64Var(&o.confirmations, "confirmations", o.confirmations, "blocks below --head the scan ends at")
+	fs.DurationVar(&o.followInterval, "follow-interval", o.followInterval, "how often the follow command looks for new blocks")
+	fs.StringVar(&o.output, "output", o.output, "deposit data output path")
+	fs.IntVar(&o.concurrency, "concurrency", o.concurrency, "number of blocks fetched in parallel")
+	fs.BoolVar(&o.adaptiveConcurrency, "adaptive-concurrency", o.adaptiveConcurrency, "adjust concurrency to provider errors and latency, up to --concurrency")
+	fs.DurationVar(&o.rpcTimeout, "rpc-timeout", o.rpcTimeout, "timeout of a single RPC call")
+	fs.IntVar(&o.retries, "retries", o.retries, "attempts of a failing RPC call before giving up")
+	fs.IntVar(&o.batchSize, "batch-size", o.batchSize, "blocks fetched per JSON-RPC batch, 0 disables batching")
+	fs.BoolVar(&o.scanLogs, "scan-logs", o.scanLogs, "find deposits with eth_getLogs instead of fetching every block")
+	fs.BoolVar(&o.crossCheckCalldata, "cross-check-calldata", o.crossCheckCalldata, "compare deposit_data_root with calldata of direct deposit calls")
+	fs.Uint64Var(&o.firstIndex, "first-index", o.firstIndex, "index of the first deposit in scanned range")
+	fs.StringVar(&o.forkVersion, "fork-version", o.forkVersion, "genesis fork version deposit signatures are verified against")
+	fs.BoolVar(&o.excludeInvalid, "exclude-invalid", o.excludeInvalid, "leave deposits with invalid signature out of deposit data")
+	fs.StringVar(&o.checkpoint, "checkpoint", o.checkpoint, "scan progress file, written periodically, empty disables it")
+	fs.StringVar(&o.cache, "cache", o.cache, "block cache directory, empty disables it")
+	fs.BoolVar(&o.resume, "resume", o.resume, "continue the scan from --checkpoint")
+	fs.StringVar(&o.chainConfig, "chain-config", o.chainConfig, "consensus layer config.yaml, genesis command only")
+	fs.StringVar(&o.executionHeader, "execution-header", o.executionHeader, "execution genesis block JSON for the payload header, genesis command only")
+	fs.StringVar(&o.genesisOutput, "genesis-output", o.genesisOutput, "genesis state output path, genesis command only")
+	fs.BoolVar(&o.genesisCandidate, "genesis-candidate", o.genesisCandidate, "end the scan at the first eth1 block yielding a valid genesis, genesis command only")
+	return fs, configPath
+}
+
+// parseOptions reads options out of args, os.Args without the program name. genesis or follow command goes first.
+func parseOptions(args []string) (*options, error) {
+	o := defaultOptions()
+	if len(args) > 0 && args[0] == "genesis" {
+		o.genesis = true
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == "follow" {
+		o.follow = true
+		args = args[1:]
+	}
+
+	// first pass only finds the config file, flags are applied on top of it in the second one
+	probe := defaultOptions()
+	probeSet, configPath := probe.flagSet()
+	probeSet.SetOutput(io.Discard)
+	err := probeSet.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+	if *configPath == "" {
+		*configPath = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	fs, _ := o.flagSet()
+	if *configPath != "" {
+		err = applyConfigFile(fs, *configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && envErr == nil && f.Name != "config" {
+			if err := fs.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if o.network != "" {
+		err = o.applyNetwork(set)
+		if err != nil {
+			return nil, err
+		}
+	}
+	o.discoverFrom = !set["from-block"] && !set["from-time"] && o.net == nil
+	o.indexFromChain = set["from-time"] && !set["first-index"]
+	if set["from-block"] && set["from-time"] {
+		return nil, errors.New("--from-block and --from-time both set the start block")
+	}
+	if set["to-block"] && set["to-time"] {
+		return nil, errors.New("--to-block and --to-time both set the end block")
+	}
+	if set["from-time"] && set["to-time"] && !o.fromTime.Before(o.toTime.Time) {
+		return nil, fmt.Errorf("empty time range %s..%s", o.fromTime.String(), o.toTime.String())
+	}
+	if o.untilDepositCount > 0 && o.untilDepositCount <= o.firstIndex {
+		return nil, fmt.Errorf("--until-deposit-count %d ends before first index %d", o.untilDepositCount, o.firstIndex)
+	}
+	if o.genesisCandidate {
+		if !o.genesis {
+			return nil, errors.New("--genesis-candidate is for the genesis command")
+		}
+		if set["to-block"] || set["to-time"] || o.untilDepositCount > 0 {
+			return nil, errors.New("--genesis-candidate picks the end block, drop --to-block, --to-time and --until-deposit-count")
+		}
+		o.latest = true
+	}
+	// genesis state takes every deposit up to its eth1 block
+	if o.genesis && o.untilDepositCount > 0 {
+		return nil, errors.New("--until-deposit-count can end the scan within a block, genesis command needs whole blocks")
+	}
+	if o.follow && (set["to-time"] || o.untilDepositCount > 0) {
+		return nil, errors.New("follow command has no end, drop --to-time and --until-deposit-count")
+	}
+	// end block is looked up below the head
+	if set["to-time"] || (o.untilDepositCount > 0 && !set["to-block"]) {
+		o.latest = true
+	}
+
+	if o.chainFiles != "" {
+		if o.follow {
+			return nil, errors.New("follow command needs RPC, chain files don't grow")
+		}
+		for _, name := range []string{"from-time", "to-time", "until-deposit-count", "genesis-candidate", "scan-logs", "verify-hashes", "resume"} {
+			if set[name] {
+				return nil, fmt.Errorf("--%s needs RPC, drop it or --chain-files", name)
+			}
+		}
+		// export files carry no receipts, deposits can only come out of Era1 archives
+		files, err := listChainFiles(o.chainFiles)
+		if err != nil {
+			return nil, err
+		}
+		era1 := false
+		for _, file := range files {
+			era1 = era1 || strings.EqualFold(filepath.Ext(file), ".era1")
+		}
+		if !era1 {
+			return nil, errors.New("--chain-files holds no Era1 archive, geth export files carry no receipts to read deposits from")
+		}
+		// files are read to the end
+		if !set["to-block"] {
+			o.latest = true
+		}
+	} else {
+		if o.rpcURL == "" {
+			return nil, errors.New("no RPC URL, set --rpc or " + envPrefix + "RPC")
+		}
+		endpoints, err := parseEndpoints(o.rpcURL)
+		if err != nil {
+			return nil, err
+		}
+		if o.verifyHashes && len(endpoints) < 2 {
+			return nil, errors.New("--verify-hashes needs at least two RPC endpoints")
+		}
+		// logs carry no blocks to check
+		if o.verifyHashes && o.scanLogs {
+			return nil, errors.New("--verify-hashes checks fetched blocks, --scan-logs fetches none")
+		}
+	}
+	if !common.IsHexAddress(o.contract) {
+		return nil, fmt.Errorf("invalid contract address %q", o.contract)
+	}
+	if !o.latest && !o.discoverFrom && o.toBlock <= o.fromBlock {
+		return nil, fmt.Errorf("empty block range %d..%d", o.fromBlock, o.toBlock)
+	}
+	if o.head != "finalized" && o.head != "safe" && o.head != "latest" {
+		return nil, fmt.Errorf("invalid head %q, want finalized, safe or latest", o.head)
+	}
+	if o.follow && !o.latest {
+		return nil, errors.New("follow command needs a scan up to the chain head, set --latest")
+	}
+	if o.follow && o.followInterval <= 0 {
+		return nil, fmt.Errorf("invalid follow interval %s", o.followInterval)
+	}
+	if o.resume && o.checkpoint == "" {
+		return nil, errors.New("--resume needs --checkpoint")
+	}
+	if o.concurrency < 1 {
+		return nil, fmt.Errorf("invalid concurrency %d", o.concurrency)
+	}
+	if o.retries < 1 {
+		return nil, fmt.Errorf("invalid retries %d", o.retries)
+	}
+	if o.batchSize < 0 {
+		return nil, fmt.Errorf("invalid batch size %d", o.batchSize)
+	}
+	if o.rpcTimeout <= 0 {
+		return nil, fmt.Errorf("invalid rpc timeout %s", o.rpcTimeout)
+	}
+	return &o, nil
+}
+
+// timeFlag is a point in time given as RFC 3339, date and time in UTC or unix seconds. Zero time is unset.
+type timeFlag struct {
+	time.Time
+}
+
+// timeLayouts are accepted forms of timeFlag, the last one is how the config file parser prints a timestamp
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+	"2006-01-02 15:04:05 -0700 MST"}
+
+func (t *timeFlag) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(value string) error {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t.Time = time.Unix(seconds, 0)
+	} else {
+		parsed := false
+		for _, layout := range timeLayouts {
+			if t.Time, err = time.Parse(layout, value); err == nil {
+				parsed = true
+				break
+			}
+		}
+		if !parsed {
+			return fmt.Errorf("invalid time %q, want RFC 3339, YYYY-MM-DD hh:mm or unix seconds", value)
+		}
+	}
+	if t.Unix() < 0 {
+		return fmt.Errorf("time %q is before 1970", value)
+	}
+	return nil
+}
+
+// unix is t in seconds, the unit of block timestamps
+func (t *timeFlag) unix() uint64 {
+	return uint64(t.Unix())
+}
+
+// depositForkVersion is the fork version deposit signatures are verified against, genesis command takes the one of
+// chain config
+func (o *options) depositForkVersion(cfg *chainConfig) (forkVersion, error) {
+	version := forkVersion{}
+	if o.genesis {
+		return cfg.GenesisForkVersion, nil
+	}
+	raw, err := hexutil.Decode(o.forkVersion)
+	if err != nil || len(raw) != len(version) {
+		return version, fmt.Errorf("invalid deposit fork version %q", o.forkVersion)
+	}
+	copy(version[:], raw)
+	return version, nil
+}
+
+// retryPolicy is how RPC calls are retried, delay between attempts starts at half a second and doubles up to
+// half a minute
+func (o *options) retryPolicy() retryPolicy {
+	return retryPolicy{attempts: o.retries, timeout: o.rpcTimeout, baseDelay: 500 * time.Millisecond, maxDelay: 30 * time.Second}
+}
+
+// applyNetwork fills options not in set from the network. Without an end block the scan goes up to the
+// latest one.
+func (o *options) applyNetwork(set map[string]bool) error {
+	net, err := loadNetwork(o.network)
+	if err != nil {
+		return err
+	}
+	o.net = net
+	if !set["contract"] {
+		o.contract = net.depositContract.Hex()
+	}
+	if !set["from-block"] {
+		o.fromBlock = net.deployBlock
+	}
+	if !set["to-block"] {
+		o.latest = true
+	}
+	if !set["fork-version"] {
+		o.forkVersion = hexutil.Encode(net.config.GenesisForkVersion[:])
+	}
+	if !set["chain-config"] {
+		o.chainConfig = ""
+	}
+	return nil
+}
+
+// loadChainConfig reads the chain config file, or takes the one of the network when there is none
+func (o *options) loadChainConfig() (*chainConfig, error) {
+	if o.chainConfig == "" && o.net != nil {
+		cfg := o.net.config
+		return &cfg, nil
+	}
+	return loadChainConfig(o.chainConfig)
+}
+
+// applyConfigFile sets flags from a YAML or TOML file, format is told by extension
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &values)
+	case ".toml":
+		err = toml.Unmarshal(raw, &values)
+	default:
+		return fmt.Errorf("unknown config format %q, want .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for name, value := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in %s", name, path)
+		}
+		err = fs.Set(name, fmt.Sprint(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// redactedRPC is the RPC URLs safe for logs
+func (o *options) redactedRPC() string {
+	endpoints, err := parseEndpoints(o.rpcURL)
+	if err != nil {
+		return "<redacted>"
+	}
+	names := make([]string, len(endpoints))
+	for i, ep := range endpoints {
+		names[i] = redactURL(ep.url)
+	}
+	return strings.Join(names, ", ")
+}
+
+// redactURL is raw safe for logs. Providers keep API keys in path, query or credentials, only scheme and host are
+// kept.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "<redacted>"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		redacted += "/<redacted>"
+	}
+	return redacted
+}
+
+// redact replaces RPC URLs in s, errors of the http transport carry them. The transport prints a URL the way it
+// parsed it: scheme lowercased, password masked, without fragment, pooled endpoints without credentials too.
+// Whatever form is left, host with path and query of an endpoint are replaced on their own.
+func (o *options) redact(s string) string {
+	if o.rpcURL == "" {
+		return s
+	}
+	replacements := map[string]string{o.rpcURL: o.redactedRPC()}
+	for _, raw := range strings.Split(o.rpcURL, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		redacted := redactURL(raw)
+		replacements[raw] = redacted
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		u.Fragment, u.RawFragment = "", ""
+		replacements[u.String()] = redacted
+		replacements[u.Redacted()] = redacted
+		if u.User != nil {
+			replacements[u.User.String()+"@"] = "<redacted>@"
+			u.User = nil
+			replacements[u.String()] = redacted
+		}
+		if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			hidden := u.Host + u.EscapedPath()
+			if u.RawQuery != "" {
+				hidden += "?" + u.RawQuery
+			}
+			replacements[hidden] = u.Host + "/<redacted>"
+		}
+	}
+	// longer forms go first, shorter ones may be part of them
+	forms := make([]string, 0, len(replacements))
+	for form := range replacements {
+		forms = append(forms, form)
+	}
+	sort.Slice(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+	for _, form := range forms {
+		s = strings.ReplaceAll(s, form, replacements[form])
+	}
+	return s
+}
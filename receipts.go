@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"strings"
+)
+
+// probeBlockReceipts finds out whether the node serves eth_getBlockReceipts by asking it for receipts of block.
+// Nodes that lack it get receipts fetched tx by tx.
+func (f *fetcher) probeBlockReceipts(ctx context.Context, block uint64) error {
+	return f.policy.do(ctx, "eth_getBlockReceipts probe", func(ctx context.Context) error {
+		var receipts []*types.Receipt
+		err := f.rpc.CallContext(ctx, &receipts, "eth_getBlockReceipts", hexutil.EncodeUint64(block))
+		if isMethodUnsupported(err) {
+			f.blockReceipts = false
+			return nil
+		}
+		f.blockReceipts = err == nil
+		return err
+	})
+}
+
+// isMethodUnsupported tells err is the node refusing an unknown method. Providers don't agree on the code, some
+// only say so in the message.
+func isMethodUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	return strings.Contains(msg, "does not exist") || strings.Contains(msg, "not available") ||
+		strings.Contains(msg, "not supported")
+}
+
+// fetchReceipts returns receipts of transactions hashes of block, in the same order. eth_getBlockReceipts takes
+// one call when available, otherwise there is one per transaction.
+func (f *fetcher) fetchReceipts(ctx context.Context, block uint64, hashes []common.Hash) ([]*types.Receipt, error) {
+	var receipts []*types.Receipt
+	if f.blockReceipts {
+		err := f.policy.do(ctx, fmt.Sprintf("receipts of block %d", block), func(ctx context.Context) error {
+			return f.rpc.CallContext(ctx, &receipts, "eth_getBlockReceipts", hexutil.EncodeUint64(block))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return receipts, checkBlockReceipts(block, hashes, receipts)
+	}
+	receipts = make([]*types.Receipt, len(hashes))
+	for i, hash := range hashes {
+		err := f.policy.do(ctx, fmt.Sprintf("receipt of tx %s", hash.Hex()), func(ctx context.Context) error {
+			var err error
+			receipts[i], err = f.eth.TransactionReceipt(ctx, hash)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return receipts, nil
+}
+
+// checkBlockReceipts makes sure receipts of block match its transactions one to one. A node behind a load
+// balancer may answer from a different chain view than the one the block came from.
+func checkBlockReceipts(block uint64, hashes []common.Hash, receipts []*types.Receipt) error {
+	if len(receipts) != len(hashes) {
+		return fmt.Errorf("block %d has %d transactions, got %d receipts", block, len(hashes), len(receipts))
+	}
+	for i, rcpt := range receipts {
+		if rcpt == nil || rcpt.TxHash != hashes[i] {
+			return fmt.Errorf("receipt %d of block %d is not of tx %s", i, block, hashes[i].Hex())
+		}
+		if rcpt.BlockNumber != nil && rcpt.BlockNumber.Uint64() != block {
+			return fmt.Errorf("receipt of tx %s is from block %s, not %d", hashes[i].Hex(), rcpt.BlockNumber, block)
+		}
+	}
+	return nil
+}
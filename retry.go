@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// retryPolicy bounds RPC calls: each attempt gets timeout, failed ones are repeated up to attempts times with
+// exponentially growing delay in between
+type retryPolicy struct {
+	attempts  int
+	timeout   time.Duration
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// observe, when set, gets the outcome of every attempt along with what the call is
+	observe func(what string, start time.Time, err error)
+}
+
+// do runs call until it succeeds, the attempts run out or ctx is done. what names the call in the error.
+func (p retryPolicy) do(ctx context.Context, what string, call func(ctx context.Context) error) error {
+	var err error
+	delay := p.baseDelay
+	for attempt := 1; attempt <= p.attempts; attempt++ {
+		start := time.Now()
+		callCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		err = call(callCtx)
+		cancel()
+		if p.observe != nil && ctx.Err() == nil {
+			p.observe(what, start, err)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt == p.attempts {
+			break
+		}
+		if p.wait(ctx, delay) != nil {
+			return ctx.Err()
+		}
+		delay = p.nextDelay(delay)
+	}
+	return fmt.Errorf("failed to fetch %s after %d attempts: %w", what, p.attempts, err)
+}
+
+// wait sleeps for delay, or until ctx is done
+func (p retryPolicy) wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nextDelay is the delay after the attempt that followed delay
+func (p retryPolicy) nextDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ejectBase is how long an endpoint rests after its first failure, each following one doubles it up to ejectMax
+	ejectBase = 5 * time.Second
+	ejectMax  = 5 * time.Minute
+)
+
+// endpointConfig is one entry of --rpc. Options go into the URL fragment, which never reaches the provider:
+// https://host/key#weight=2&concurrency=10
+type endpointConfig struct {
+	url string
+	// weight is the share of requests relative to other endpoints
+	weight int
+	// concurrency caps requests in flight, 0 is unlimited
+	concurrency int
+}
+
+// parseEndpoints splits a comma separated --rpc value into endpoints
+func parseEndpoints(list string) ([]endpointConfig, error) {
+	endpoints := make([]endpointConfig, 0)
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RPC URL %s: %w", redactURL(raw), err)
+		}
+		ep := endpointConfig{weight: 1}
+		params, err := url.ParseQuery(u.Fragment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options of RPC %s: %w", redactURL(raw), err)
+		}
+		for key := range params {
+			value, err := strconv.Atoi(params.Get(key))
+			switch {
+			case key != "weight" && key != "concurrency":
+				return nil, fmt.Errorf("unknown option %q of RPC %s", key, redactURL(raw))
+			case err != nil || value < 0 || (key == "weight" && value == 0):
+				return nil, fmt.Errorf("invalid %s of RPC %s", key, redactURL(raw))
+			case key == "weight":
+				ep.weight = value
+			default:
+				ep.concurrency = value
+			}
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+		ep.url = u.String()
+		endpoints = append(endpoints, ep)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no RPC URL")
+	}
+	return endpoints, nil
+}
+
+type endpoint struct {
+	endpointConfig
+	target   *url.URL
+	name     string
+	inFlight int
+	// failures in a row, the endpoint is left alone until ejectedUntil
+	failures     int
+	ejectedUntil time.Time
+}
+
+// rpcPool spreads HTTP requests of a single rpc.Client over several endpoints. Each request goes to a random one,
+// picked by weight among those under their concurrency limit. Endpoints that fail or rate limit are ejected for a
+// while, retries of the call land elsewhere. When every endpoint is ejected the one back the soonest still serves.
+type rpcPool struct {
+	endpoints []*endpoint
+	transport http.RoundTripper
+
+	mut sync.Mutex
+	// wake is closed when a request finishes, waiters for a free endpoint try again
+	wake chan struct{}
+}
+
+func newRPCPool(configs []endpointConfig) (*rpcPool, error) {
+	p := &rpcPool{transport: http.DefaultTransport, wake: make(chan struct{})}
+	for _, cfg := range configs {
+		target, err := url.Parse(cfg.url)
+		if err != nil {
+			return nil, err
+		}
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return nil, fmt.Errorf("RPC %s: only http and https endpoints can be pooled or capped", redactURL(cfg.url))
+		}
+		p.endpoints = append(p.endpoints, &endpoint{endpointConfig: cfg, target: target, name: redactURL(cfg.url)})
+	}
+	return p, nil
+}
+
+// dial returns a client whose every request goes through the pool
+func (p *rpcPool) dial() (*rpc.Client, error) {
+	return rpc.DialHTTPWithClient(p.endpoints[0].url, &http.Client{Transport: batchErrorTransport{transport: p}})
+}
+
+type endpointKey struct{}
+
+// withEndpoint pins calls made with ctx to endpoint i of the pool, ejected or not
+func withEndpoint(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, endpointKey{}, i)
+}
+
+func (p *rpcPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	pinned, ok := ctx.Value(endpointKey{}).(int)
+	if !ok {
+		pinned = -1
+	}
+	ep, err := p.acquire(ctx, pinned)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(ctx)
+	target := *ep.target
+	out.URL = &target
+	out.Host = ""
+	if target.User != nil {
+		password, _ := target.User.Password()
+		out.SetBasicAuth(target.User.Username(), password)
+		out.URL.User = nil
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		p.release(ep)
+		if ctx.Err() == nil {
+			p.failed(ep, err.Error())
+		}
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// rpc client doesn't close bodies of failed requests, the slot is freed here instead
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		p.release(ep)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			p.failed(ep, resp.Status)
+		}
+		return resp, nil
+	}
+	p.succeeded(ep)
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { p.release(ep) }}
+	return resp, nil
+}
+
+// acquire waits for an endpoint to take a request, pinned one when pinned isn't -1
+func (p *rpcPool) acquire(ctx context.Context, pinned int) (*endpoint, error) {
+	for {
+		p.mut.Lock()
+		ep := p.pick(pinned)
+		if ep != nil {
+			ep.inFlight++
+			p.mut.Unlock()
+			return ep, nil
+		}
+		wake := p.wake
+		p.mut.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *rpcPool) pick(pinned int) *endpoint {
+	free := func(ep *endpoint) bool { return ep.concurrency == 0 || ep.inFlight < ep.concurrency }
+	if pinned >= 0 {
+		if free(p.endpoints[pinned]) {
+			return p.endpoints[pinned]
+		}
+		return nil
+	}
+	now := time.Now()
+	total := 0
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	allEjected := true
+	var soonest *endpoint
+	for _, ep := range p.endpoints {
+		if now.Before(ep.ejectedUntil) {
+			if soonest == nil || ep.ejectedUntil.Before(soonest.ejectedUntil) {
+				soonest = ep
+			}
+			continue
+		}
+		allEjected = false
+		if free(ep) {
+			candidates = append(candidates, ep)
+			total += ep.weight
+		}
+	}
+	if allEjected {
+		if free(soonest) {
+			return soonest
+		}
+		return nil
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for _, ep := range candidates {
+		n -= ep.weight
+		if n < 0 {
+			return ep
+		}
+	}
+	return nil
+}
+
+func (p *rpcPool) release(ep *endpoint) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	ep.inFlight--
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+func (p *rpcPool) failed(ep *endpoint, reason string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	ep.failures++
+	pause := ejectBase
+	for i := 1; i < ep.failures && pause < ejectMax; i++ {
+		pause *= 2
+	}
+	if pause > ejectMax {
+		pause = ejectMax
+	}
+	ep.ejectedUntil = time.Now().Add(pause)
+	fmt.Printf("warning: RPC %s ejected for %s: %s\n", ep.name, pause, reason)
+}
+
+func (p *rpcPool) succeeded(ep *endpoint) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	ep.failures = 0
+}
+
+// pair picks two different endpoints by weight, ejected ones only when there are not enough others
+func (p *rpcPool) pair() (int, int) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	now := time.Now()
+	order := rand.Perm(len(p.endpoints))
+	picked := make([]int, 0, 2)
+	for _, ejected := range []bool{false, true} {
+		// weighted draw without replacement: endpoint i goes first with probability weight/total
+		remaining := make([]int, 0, len(order))
+		for _, i := range order {
+			if now.Before(p.endpoints[i].ejectedUntil) == ejected {
+				remaining = append(remaining, i)
+			}
+		}
+		for len(picked) < 2 && len(remaining) > 0 {
+			total := 0
+			for _, i := range remaining {
+				total += p.endpoints[i].weight
+			}
+			n := rand.Intn(total)
+			for k, i := range remaining {
+				n -= p.endpoints[i].weight
+				if n < 0 {
+					picked = append(picked, i)
+					remaining = append(remaining[:k], remaining[k+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return picked[0], picked[1]
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// checkBlockHashes asks two different endpoints of the pool for hashes of blocks and returns them once they agree.
+// A disagreement at the head usually settles, so it is retried like a failed call.
+func (f *fetcher) checkBlockHashes(ctx context.Context, blocks []uint64) ([]common.Hash, error) {
+	what := fmt.Sprintf("agreeing hashes of blocks %d..%d", blocks[0], blocks[len(blocks)-1])
+	var agreed []common.Hash
+	err := f.policy.do(ctx, what, func(ctx context.Context) error {
+		a, b := f.pool.pair()
+		first, err := f.blockHashes(withEndpoint(ctx, a), blocks)
+		if err != nil {
+			return err
+		}
+		second, err := f.blockHashes(withEndpoint(ctx, b), blocks)
+		if err != nil {
+			return err
+		}
+		for i := range blocks {
+			if first[i] != second[i] {
+				return fmt.Errorf("block %d is %s at RPC %s, %s at RPC %s", blocks[i],
+					first[i].Hex(), f.pool.endpoints[a].name, second[i].Hex(), f.pool.endpoints[b].name)
+			}
+		}
+		agreed = first
+		return nil
+	})
+	return agreed, err
+}
+
+// checkAgreedBlock fails when block, as hash and receipts came from the pool, is not the one endpoints agreed on.
+// The block and its receipts may come from yet another endpoint than the two that were asked. Zero agreed hash
+// skips the check.
+func checkAgreedBlock(block uint64, agreed, hash common.Hash, receipts []*types.Receipt) error {
+	if agreed == (common.Hash{}) {
+		return nil
+	}
+	if hash != agreed {
+		return fmt.Errorf("block %d fetched is %s, RPC endpoints agreed on %s", block, hash.Hex(), agreed.Hex())
+	}
+	for _, rcpt := range receipts {
+		if rcpt.BlockHash != agreed {
+			return fmt.Errorf("receipt of tx %s is of block %s, RPC endpoints agreed on %s for block %d",
+				rcpt.TxHash.Hex(), rcpt.BlockHash.Hex(), agreed.Hex(), block)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestVerifyHashesFetchedBlock makes sure blocks and receipts that get used are the ones two endpoints agreed on,
+// one by one and batched
+func TestVerifyHashesFetchedBlock(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	for _, tc := range []struct {
+		name string
+		// reorg changes blocks once both endpoints told their hashes
+		reorg bool
+		// foreignReceipts serves receipts of another block
+		foreignReceipts bool
+		err             string
+	}{
+		{name: "agreed"},
+		{name: "reorged", reorg: true, err: "fetched is"},
+		{name: "foreign receipts", foreignReceipts: true, err: "receipt of tx"},
+	} {
+		for _, batchSize := range []int{0, 2} {
+			chain := newStubChain(contract, 2)
+			if tc.foreignReceipts {
+				for _, rcpt := range chain.blocks[1].receipts {
+					rcpt.BlockHash = common.Hash{0xff}
+				}
+			}
+			handlers := chain.handlers()
+			blockByNumber := handlers["eth_getBlockByNumber"]
+			calls := make(map[string]int)
+			mut := sync.Mutex{}
+			handlers["eth_getBlockByNumber"] = func(params []json.RawMessage) (interface{}, error) {
+				mut.Lock()
+				calls[string(params[0])]++
+				reorged := tc.reorg && calls[string(params[0])] > 2
+				mut.Unlock()
+				result, err := blockByNumber(params)
+				if reorged && result != nil {
+					result.(map[string]interface{})["hash"] = common.Hash{0xee}
+				}
+				return result, err
+			}
+			_, first := newRPCStub(t, handlers)
+			_, second := newRPCStub(t, handlers)
+			endpoints, err := parseEndpoints(first.URL + "," + second.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool, err := newRPCPool(endpoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := pool.dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := newFetcher(client, testPolicy(), batchSize)
+			f.blockReceipts = true
+			f.pool, f.verifyHashes = pool, true
+			_, err = f.fetchBlocks(context.Background(), []uint64{0, 1}, contract)
+			client.Close()
+			if tc.err == "" && err != nil {
+				t.Errorf("%s, batch %d: %v", tc.name, batchSize, err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("%s, batch %d: got %v, want %q", tc.name, batchSize, err, tc.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+)
+
+// scanner scans block ranges for deposits, through logs or block by block as options say
+type scanner struct {
+	opts     *options
+	rpc      *rpc.Client
+	eth      *ethclient.Client
+	deposits *binding.Binding
+	addr     common.Address
+	policy   retryPolicy
+	f        *fetcher
+	cache    *blockCache
+	// noFinalized is set once the node turned out to have no finalized block
+	noFinalized bool
+	// finalEnd is the first block that could still reorg as of the last scan, tailFrom is clamped to its range
+	finalEnd uint64
+	// tailHashes are hashes of the blocks of the last scan that may still reorg, starting at block tailFrom
+	tailFrom   uint64
+	tailHashes []common.Hash
+}
+
+func (s *scanner) fetch(ctx context.Context, from, to uint64, cp *checkpointer) ([]fetchBlockOutput, error) {
+	if s.opts.scanLogs {
+		return logScanFetch(ctx, s.f, s.deposits, from, to, s.policy, cp)
+	}
+	return multiThreadedFetch(ctx, s.f, from, to, s.addr, s.opts.concurrency, s.cache, cp)
+}
+
+// scan fetches blocks [from, to). Finalized blocks are scanned once and go to cp. The rest may reorg while
+// scanned, they are checked afterwards and left out of cp. Their hashes are kept in tailHashes.
+func (s *scanner) scan(ctx context.Context, from, to uint64, cp *checkpointer) ([]fetchBlockOutput, error) {
+	s.tailFrom, s.tailHashes = to, nil
+	unfinalizedFrom, ok, err := finalizedEnd(ctx, s.rpc, s.policy)
+	if err != nil {
+		return nil, err
+	}
+	s.finalEnd = unfinalizedFrom
+	if !ok && !s.noFinalized {
+		s.noFinalized = true
+		fmt.Printf("node has no finalized block, treating the last %d blocks below head as unfinalized\n", cacheFinalityDepth)
+	}
+	if unfinalizedFrom < from {
+		unfinalizedFrom = from
+	}
+	if unfinalizedFrom > to {
+		unfinalizedFrom = to
+	}
+	var fetched []fetchBlockOutput
+	if from < unfinalizedFrom {
+		fetched, err = s.fetch(ctx, from, unfinalizedFrom, cp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if unfinalizedFrom < to {
+		unfinalized, hashes, err := scanUnfinalized(ctx, s.f, func(from, to uint64) ([]fetchBlockOutput, error) {
+			return s.fetch(ctx, from, to, nil)
+		}, unfinalizedFrom, to)
+		if err != nil {
+			return nil, err
+		}
+		fetched = append(fetched, unfinalized...)
+		s.tailFrom, s.tailHashes = unfinalizedFrom, hashes
+	}
+	return fetched, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/schollz/progressbar/v3"
+	"runtime"
+	"sync"
+)
+
+var domainDeposit = [4]byte{0x03, 0x00, 0x00, 0x00}
+
+// depositDomain is compute_domain(DOMAIN_DEPOSIT, fork_version) with zero genesis validators root. Deposits are
+// valid across forks, so they are always signed with the genesis fork version.
+func depositDomain(version forkVersion) [32]byte {
+	var zeroRoot [32]byte
+	forkDataRoot := sszContainer(sszByteVector(version[:]), sszByteVector(zeroRoot[:])).root
+	var domain [32]byte
+	copy(domain[:4], domainDeposit[:])
+	copy(domain[4:], forkDataRoot[:28])
+	return domain
+}
+
+// verifyDepositSignature checks the signature over the DepositMessage of a deposit, as is_valid_deposit_signature
+// of the spec does. Malformed fields fail verification.
+func verifyDepositSignature(data JSONData, domain [32]byte) bool {
+	pubkey, withdrawalCredentials, signature, err := data.decode()
+	if err != nil {
+		return false
+	}
+	messageRoot := sszContainer(sszByteVector(pubkey[:]), sszByteVector(withdrawalCredentials[:]), sszUint64(data.Amount)).root
+	signingRoot := sszContainer(sszByteVector(messageRoot[:]), sszByteVector(domain[:])).root
+	return blsVerify(pubkey, signingRoot, signature)
+}
+
+// markValidDeposits sets Valid on deposits the consensus layer would process. Like process_deposit of the spec only
+// the first deposit of a pubkey needs a valid signature, the rest are top ups of the validator it creates. A
+// deposit with invalid signature doesn't create the validator, so the next deposit of that pubkey is first again.
+// Deposits have to be ordered, when scan doesn't start at index 0 earlier deposits of a pubkey aren't known.
+// Returns the number of invalid deposits.
+func markValidDeposits(deps []scannedDeposit, version forkVersion) int {
+	domain := depositDomain(version)
+	byPubkey := make(map[string][]int)
+	pubkeys := make([]string, 0)
+	for i, dep := range deps {
+		if _, ok := byPubkey[dep.data.Pubkey]; !ok {
+			pubkeys = append(pubkeys, dep.data.Pubkey)
+		}
+		byPubkey[dep.data.Pubkey] = append(byPubkey[dep.data.Pubkey], i)
+	}
+
+	// pubkeys are independent of each other, verify them in parallel
+	bar := progressbar.Default(int64(len(pubkeys)), "verifying signatures...")
+	work := make(chan []int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indices := range work {
+				registered := false
+				for _, i := range indices {
+					if !registered {
+						registered = verifyDepositSignature(deps[i].data, domain)
+					}
+					deps[i].data.Valid = registered
+				}
+				_ = bar.Add(1)
+			}
+		}()
+	}
+	for _, pubkey := range pubkeys {
+		work <- byPubkey[pubkey]
+	}
+	close(work)
+	wg.Wait()
+
+	invalid := 0
+	for _, dep := range deps {
+		if !dep.data.Valid {
+			invalid++
+		}
+	}
+	return invalid
+}
+
+// registeredPubkeys are pubkeys of deposits marked valid, the ones with a validator
+func registeredPubkeys(deps []scannedDeposit) map[string]bool {
+	registered := make(map[string]bool)
+	for _, dep := range deps {
+		if dep.data.Valid {
+			registered[dep.data.Pubkey] = true
+		}
+	}
+	return registered
+}
+
+// markValidFollowing is markValidDeposits for deposits following the ones registered comes from, it only verifies
+// signatures of pubkeys not registered yet. registered takes in new pubkeys. Returns the number of invalid deposits.
+func markValidFollowing(deps []scannedDeposit, registered map[string]bool, version forkVersion) int {
+	domain := depositDomain(version)
+	invalid := 0
+	for i := range deps {
+		pubkey := deps[i].data.Pubkey
+		if !registered[pubkey] {
+			registered[pubkey] = verifyDepositSignature(deps[i].data, domain)
+		}
+		deps[i].data.Valid = registered[pubkey]
+		if !deps[i].data.Valid {
+			invalid++
+		}
+	}
+	return invalid
+}
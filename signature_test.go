@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"testing"
+)
+
+func TestDepositDomain(t *testing.T) {
+	domain := depositDomain(forkVersion{})
+	want := "0x03000000f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a9"
+	if got := hexutil.Encode(domain[:]); got != want {
+		t.Fatalf("mainnet deposit domain %s, want %s", got, want)
+	}
+}
+
+// TestMarkValidDeposits checks deposit_data.json against the mainnet domain, all of its deposits verify but the
+// one with a hand mangled pubkey
+func TestMarkValidDeposits(t *testing.T) {
+	data := loadDepositData(t)
+	deps := make([]scannedDeposit, len(data))
+	for i, d := range data {
+		deps[i] = scannedDeposit{index: uint64(i), data: d}
+	}
+	invalid := markValidDeposits(deps, forkVersion{})
+	if invalid != 1 {
+		t.Fatalf("%d of %d deposits invalid, want 1", invalid, len(deps))
+	}
+	for _, dep := range deps {
+		_, _, _, err := dep.data.decode()
+		if dep.data.Valid != (err == nil) {
+			t.Errorf("deposit %d valid %v, decode error %v", dep.index, dep.data.Valid, err)
+		}
+	}
+}
+
+// TestMarkValidRepeatedPubkey has a pubkey whose first deposit carries a signature of another deposit. It creates no
+// validator, so the next deposit of the pubkey needs a valid signature, and the one after is a top up.
+func TestMarkValidRepeatedPubkey(t *testing.T) {
+	data := loadDepositData(t)
+	forged := data[0]
+	forged.Signature = data[1].Signature
+	topUp := forged
+	topUp.Amount = 1000000000
+	want := []bool{false, true, true}
+	newDeps := func() []scannedDeposit {
+		deps := make([]scannedDeposit, 0, len(want))
+		for i, d := range []JSONData{forged, data[0], topUp} {
+			deps = append(deps, scannedDeposit{index: uint64(i), data: d})
+		}
+		return deps
+	}
+
+	deps := newDeps()
+	if invalid := markValidDeposits(deps, forkVersion{}); invalid != 1 {
+		t.Errorf("markValidDeposits: %d invalid, want 1", invalid)
+	}
+	for i, dep := range deps {
+		if dep.data.Valid != want[i] {
+			t.Errorf("markValidDeposits: deposit %d valid %v, want %v", i, dep.data.Valid, want[i])
+		}
+	}
+
+	deps = newDeps()
+	registered := make(map[string]bool)
+	if invalid := markValidFollowing(deps, registered, forkVersion{}); invalid != 1 {
+		t.Errorf("markValidFollowing: %d invalid, want 1", invalid)
+	}
+	for i, dep := range deps {
+		if dep.data.Valid != want[i] {
+			t.Errorf("markValidFollowing: deposit %d valid %v, want %v", i, dep.data.Valid, want[i])
+		}
+	}
+	if !registered[data[0].Pubkey] {
+		t.Error("markValidFollowing: pubkey not registered")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// minimal SSZ encoding and merkleization, just enough for the containers this tool has to produce
+
+const bytesPerLengthOffset = 4
+
+var zeroHashes = func() [65][32]byte {
+	var out [65][32]byte
+	for i := 1; i < len(out); i++ {
+		out[i] = hashPair(out[i-1], out[i-1])
+	}
+	return out
+}()
+
+func hashPair(a, b [32]byte) [32]byte {
+	return sha256.Sum256(append(a[:], b[:]...))
+}
+
+// packBytes splits data into 32 byte chunks, right padding the last one with zeros
+func packBytes(data []byte) [][32]byte {
+	chunks := make([][32]byte, (len(data)+31)/32)
+	for i := range chunks {
+		copy(chunks[i][:], data[i*32:])
+	}
+	return chunks
+}
+
+func uint64Chunk(v uint64) [32]byte {
+	var chunk [32]byte
+	binary.LittleEndian.PutUint64(chunk[:8], v)
+	return chunk
+}
+
+// merkleize builds the root of chunks padded with zero chunks up to the next power of two of len(chunks)
+func merkleize(chunks [][32]byte) [32]byte {
+	return merkleizeLimit(chunks, uint64(len(chunks)))
+}
+
+// merkleizeLimit builds the root of chunks padded with zero chunks up to the next power of two of limit. Padding
+// subtrees are taken from zeroHashes, so huge limits (validator registry) cost nothing.
+func merkleizeLimit(chunks [][32]byte, limit uint64) [32]byte {
+	if uint64(len(chunks)) > limit {
+		panic(fmt.Sprintf("ssz: %d chunks over limit %d", len(chunks), limit))
+	}
+	depth := 0
+	for uint64(1)<<depth < limit {
+		depth++
+	}
+	if len(chunks) == 0 {
+		return zeroHashes[depth]
+	}
+	layer := make([][32]byte, len(chunks))
+	copy(layer, chunks)
+	for d := 0; d < depth; d++ {
+		if len(layer)%2 == 1 {
+			layer = append(layer, zeroHashes[d])
+		}
+		next := make([][32]byte, len(layer)/2)
+		for i := range next {
+			next[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func mixInLength(root [32]byte, length uint64) [32]byte {
+	return hashPair(root, uint64Chunk(length))
+}
+
+// sszValue is a serialized SSZ value along with its hash_tree_root
+type sszValue struct {
+	data     []byte
+	root     [32]byte
+	variable bool
+}
+
+func sszUint64(v uint64) sszValue {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, v)
+	return sszValue{data: data, root: uint64Chunk(v)}
+}
+
+func sszUint8(v uint8) sszValue {
+	return sszValue{data: []byte{v}, root: [32]byte{v}}
+}
+
+func sszBool(v bool) sszValue {
+	if v {
+		return sszUint8(1)
+	}
+	return sszUint8(0)
+}
+
+// sszByteVector encodes fixed size byte arrays, Bytes32, BLSPubkey, Version and alike
+func sszByteVector(b []byte) sszValue {
+	data := make([]byte, len(b))
+	copy(data, b)
+	return sszValue{data: data, root: merkleize(packBytes(data))}
+}
+
+// sszByteList encodes List[byte, limit]
+func sszByteList(b []byte, limit uint64) sszValue {
+	data := make([]byte, len(b))
+	copy(data, b)
+	return sszValue{data: data, root: mixInLength(merkleizeLimit(packBytes(data), (limit+31)/32), uint64(len(b))), variable: true}
+}
+
+// sszBitvector encodes Bitvector[n], bits is the already packed little endian bit field
+func sszBitvector(bits []byte) sszValue {
+	return sszByteVector(bits)
+}
+
+func packUint64s(vals []uint64) []byte {
+	data := make([]byte, 8*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(data[8*i:], v)
+	}
+	return data
+}
+
+// sszUint64Vector encodes Vector[uint64, len(vals)]
+func sszUint64Vector(vals []uint64) sszValue {
+	data := packUint64s(vals)
+	return sszValue{data: data, root: merkleize(packBytes(data))}
+}
+
+// sszUint64List encodes List[uint64, limit]
+func sszUint64List(vals []uint64, limit uint64) sszValue {
+	data := packUint64s(vals)
+	return sszValue{data: data, root: mixInLength(merkleizeLimit(packBytes(data), (limit*8+31)/32), uint64(len(vals))), variable: true}
+}
+
+// sszVector encodes Vector[T, len(elems)] of composite or Bytes32 elements
+func sszVector(elems []sszValue) sszValue {
+	data, roots := joinElements(elems)
+	return sszValue{data: data, root: merkleize(roots), variable: len(elems) > 0 && elems[0].variable}
+}
+
+// sszList encodes List[T, limit] of composite or Bytes32 elements
+func sszList(elems []sszValue, limit uint64) sszValue {
+	data, roots := joinElements(elems)
+	return sszValue{data: data, root: mixInLength(merkleizeLimit(roots, limit), uint64(len(elems))), variable: true}
+}
+
+func joinElements(elems []sszValue) ([]byte, [][32]byte) {
+	roots := make([][32]byte, len(elems))
+	for i, e := range elems {
+		roots[i] = e.root
+	}
+	return encodeSequence(elems), roots
+}
+
+// sszContainer encodes a container out of its fields, in declaration order
+func sszContainer(fields ...sszValue) sszValue {
+	roots := make([][32]byte, len(fields))
+	variable := false
+	for i, f := range fields {
+		roots[i] = f.root
+		variable = variable || f.variable
+	}
+	return sszValue{data: encodeSequence(fields), root: merkleize(roots), variable: variable}
+}
+
+// encodeSequence lays out fixed parts first with offsets in place of variable size parts, which follow after
+func encodeSequence(parts []sszValue) []byte {
+	fixedLen, total := 0, 0
+	for _, p := range parts {
+		if p.variable {
+			fixedLen += bytesPerLengthOffset
+		} else {
+			fixedLen += len(p.data)
+		}
+		total += len(p.data)
+	}
+	out := make([]byte, 0, fixedLen+total)
+	offset := fixedLen
+	for _, p := range parts {
+		if !p.variable {
+			out = append(out, p.data...)
+			continue
+		}
+		out = binary.LittleEndian.AppendUint32(out, uint32(offset))
+		offset += len(p.data)
+	}
+	for _, p := range parts {
+		if p.variable {
+			out = append(out, p.data...)
+		}
+	}
+	return out
+}
+
+// depositDataRoot is hash_tree_root of the DepositData container, the same computation as deposit() in
+// contract.sol does to validate the deposit_data_root argument.
+func depositDataRoot(pubkey, withdrawalCredentials []byte, amount uint64, signature []byte) ([32]byte, error) {
+	if len(pubkey) != 48 {
+		return [32]byte{}, fmt.Errorf("invalid pubkey length %d", len(pubkey))
+	}
+	if len(withdrawalCredentials) != 32 {
+		return [32]byte{}, fmt.Errorf("invalid withdrawal credentials length %d", len(withdrawalCredentials))
+	}
+	if len(signature) != 96 {
+		return [32]byte{}, fmt.Errorf("invalid signature length %d", len(signature))
+	}
+	return sszContainer(
+		sszByteVector(pubkey),
+		sszByteVector(withdrawalCredentials),
+		sszUint64(amount),
+		sszByteVector(signature),
+	).root, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/m8b-dev/spike-deposit-2-genesis/contract/binding"
+	"os"
+	"testing"
+)
+
+// loadDepositData reads deposit_data.json, deposits of a real deposit contract along with the deposit_data_root
+// each was made with
+func loadDepositData(t *testing.T) []JSONData {
+	t.Helper()
+	raw, err := os.ReadFile("deposit_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []JSONData
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Fatal("no deposits in deposit_data.json")
+	}
+	return data
+}
+
+func TestDepositDataRoot(t *testing.T) {
+	checked := 0
+	for i, d := range loadDepositData(t) {
+		// sample holds a hand mangled pubkey, its root can't be checked
+		pubkey, withdrawalCredentials, signature, err := d.decode()
+		if err != nil {
+			continue
+		}
+		root, err := depositDataRoot(pubkey[:], withdrawalCredentials[:], d.Amount, signature[:])
+		if err != nil {
+			t.Fatalf("deposit %d: %v", i, err)
+		}
+		if got := hexutil.Encode(root[:]); got != d.DepositDataRoot {
+			t.Fatalf("deposit %d: root %s, want %s", i, got, d.DepositDataRoot)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("no deposit in deposit_data.json decodes")
+	}
+}
+
+func TestDepositDataRootLengths(t *testing.T) {
+	for _, tc := range []struct {
+		name                                     string
+		pubkey, withdrawalCredentials, signature int
+	}{
+		{"pubkey", 47, 32, 96},
+		{"withdrawal credentials", 48, 33, 96},
+		{"signature", 48, 32, 95},
+	} {
+		_, err := depositDataRoot(make([]byte, tc.pubkey), make([]byte, tc.withdrawalCredentials), 1, make([]byte, tc.signature))
+		if err == nil {
+			t.Fatalf("invalid %s length accepted", tc.name)
+		}
+	}
+}
+
+func TestOrderDeposits(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		indexes    []uint64
+		firstIndex uint64
+		ok         bool
+	}{
+		{"in order", []uint64{0, 1, 2}, 0, true},
+		{"shuffled", []uint64{2, 0, 1}, 0, true},
+		{"non-zero first index", []uint64{6, 5, 7}, 5, true},
+		{"gap", []uint64{0, 1, 3}, 0, false},
+		{"missing first", []uint64{1, 2}, 0, false},
+		{"duplicate", []uint64{0, 1, 1, 2}, 0, false},
+		{"below first index", []uint64{4, 5, 6}, 5, false},
+	} {
+		deps := make([]scannedDeposit, len(tc.indexes))
+		for i, index := range tc.indexes {
+			deps[i] = scannedDeposit{index: index, block: index}
+		}
+		err := orderDeposits(deps, tc.firstIndex)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got %v", tc.name, err)
+			continue
+		}
+		for i := 1; i < len(deps); i++ {
+			if deps[i].index < deps[i-1].index {
+				t.Errorf("%s: deposit %d before %d", tc.name, deps[i-1].index, deps[i].index)
+			}
+		}
+	}
+}
+
+// TestExtractDepositsBatchReceipt has a receipt of a batch deposit, with its deposit logs out of order and a log
+// of another contract that looks the same
+func TestExtractDepositsBatchReceipt(t *testing.T) {
+	contract := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	deposits, err := binding.NewBinding(contract, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := loadDepositData(t)
+	txHash := common.HexToHash("0x01")
+	lookalike := depositEventLog(t, contract, data[3], 3, 10, txHash, 0)
+	lookalike.Address = common.HexToAddress("0x0000000000000000000000000000000000000001")
+	logs := []*types.Log{
+		depositEventLog(t, contract, data[2], 7, 10, txHash, 3),
+		lookalike,
+		depositEventLog(t, contract, data[0], 5, 10, txHash, 1),
+		depositEventLog(t, contract, data[1], 6, 10, txHash, 2),
+	}
+	found, err := extractDeposits(deposits, contract, []fetchBlockOutput{{block: 10, data: []fetchBlockEntry{{hash: txHash, logs: logs}}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("got %d deposits, want 3", len(found))
+	}
+	for i, dep := range found {
+		if dep.index != uint64(5+i) || dep.logIndex != uint(i+1) || dep.block != 10 || dep.txHash != txHash || dep.data.Pubkey != data[i].Pubkey {
+			t.Errorf("deposit %d: index %d, log %d, block %d, tx %s, pubkey %s", i, dep.index, dep.logIndex, dep.block, dep.txHash.Hex(), dep.data.Pubkey)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var domainSyncCommittee = [4]byte{0x07, 0x00, 0x00, 0x00}
+
+type syncCommittee struct {
+	pubkeys         [][48]byte
+	aggregatePubkey [48]byte
+}
+
+func (c *syncCommittee) ssz() sszValue {
+	pubkeys := make([]sszValue, len(c.pubkeys))
+	for i := range c.pubkeys {
+		pubkeys[i] = sszByteVector(c.pubkeys[i][:])
+	}
+	return sszContainer(sszVector(pubkeys), sszByteVector(c.aggregatePubkey[:]))
+}
+
+// computeShuffledIndex is compute_shuffled_index of the spec, the swap-or-not shuffle
+func computeShuffledIndex(index, count uint64, seed [32]byte, rounds uint64) uint64 {
+	buf := make([]byte, 0, 32+1+4)
+	for round := uint64(0); round < rounds; round++ {
+		buf = append(append(buf[:0], seed[:]...), byte(round))
+		pivotHash := sha256.Sum256(buf)
+		pivot := binary.LittleEndian.Uint64(pivotHash[:8]) % count
+		flip := (pivot + count - index) % count
+		position := index
+		if flip > position {
+			position = flip
+		}
+		source := sha256.Sum256(binary.LittleEndian.AppendUint32(buf, uint32(position/256)))
+		if (source[(position%256)/8]>>(position%8))%2 == 1 {
+			index = flip
+		}
+	}
+	return index
+}
+
+// nextSyncCommittee is get_next_sync_committee of the genesis state. All randao mixes are the eth1 block hash at
+// genesis, so the seed is derived from it directly.
+func (s *genesisState) nextSyncCommittee() (*syncCommittee, error) {
+	p := s.cfg.preset()
+	epoch := genesisEpoch + 1
+	active := make([]uint64, 0, len(s.validators))
+	for i, v := range s.validators {
+		if v.activationEpoch <= epoch && epoch < v.exitEpoch {
+			active = append(active, uint64(i))
+		}
+	}
+	if len(active) == 0 {
+		return nil, errors.New("no active validators to fill sync committee")
+	}
+	seed := sha256.Sum256(append(binary.LittleEndian.AppendUint64(domainSyncCommittee[:], epoch), s.eth1Data.blockHash[:]...))
+
+	// electra samples with 16 bit random values against the larger max effective balance
+	maxRandom, maxEffectiveBalance, randomPerHash := uint64(1<<8-1), p.maxEffectiveBalance, uint64(32)
+	if s.fork >= forkElectra {
+		maxRandom, maxEffectiveBalance, randomPerHash = 1<<16-1, p.maxEffectiveBalanceElectra, 16
+	}
+	count := uint64(len(active))
+	committee := &syncCommittee{pubkeys: make([][48]byte, 0, p.syncCommitteeSize)}
+	var randomHash [32]byte
+	for i := uint64(0); uint64(len(committee.pubkeys)) < p.syncCommitteeSize; i++ {
+		candidate := active[computeShuffledIndex(i%count, count, seed, p.shuffleRoundCount)]
+		if i%randomPerHash == 0 {
+			randomHash = sha256.Sum256(binary.LittleEndian.AppendUint64(seed[:], i/randomPerHash))
+		}
+		var random uint64
+		if s.fork >= forkElectra {
+			offset := i % randomPerHash * 2
+			random = uint64(binary.LittleEndian.Uint16(randomHash[offset : offset+2]))
+		} else {
+			random = uint64(randomHash[i%randomPerHash])
+		}
+		if s.validators[candidate].effectiveBalance*maxRandom >= maxEffectiveBalance*random {
+			committee.pubkeys = append(committee.pubkeys, s.validators[candidate].pubkey)
+		}
+	}
+	aggregate, err := aggregatePubkeys(committee.pubkeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sync committee: %w", err)
+	}
+	committee.aggregatePubkey = aggregate
+	return committee, nil
+}
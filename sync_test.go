@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// TestComputeShuffledIndex runs cases the way the consensus-spec shuffling tests are made, seed i being the hash of
+// i as 4 little endian bytes and mapping holding compute_shuffled_index of every index
+func TestComputeShuffledIndex(t *testing.T) {
+	for _, tc := range []struct {
+		seed    uint32
+		rounds  uint64
+		mapping []uint64
+	}{
+		// minimal preset
+		{0, 10, []uint64{8, 9, 6, 7, 4, 3, 5, 1, 0, 2}},
+		{1, 10, []uint64{2, 3, 9, 8, 7, 4, 6, 0, 5, 1}},
+		{2, 10, []uint64{2, 6, 0, 3, 8, 4, 5, 7, 1, 9}},
+		// mainnet preset
+		{0, 90, []uint64{7, 4, 3, 2, 0, 5, 1, 8, 6, 9}},
+		{1, 90, []uint64{2, 3, 7, 9, 4, 5, 1, 0, 8, 6}},
+		{2, 90, []uint64{1, 5, 4, 3, 9, 6, 8, 7, 2, 0}},
+	} {
+		seed := sha256.Sum256(binary.LittleEndian.AppendUint32(nil, tc.seed))
+		count := uint64(len(tc.mapping))
+		for i, want := range tc.mapping {
+			got := computeShuffledIndex(uint64(i), count, seed, tc.rounds)
+			if got != want {
+				t.Fatalf("seed %d, %d rounds: index %d shuffled to %d, want %d", tc.seed, tc.rounds, i, got, want)
+			}
+		}
+	}
+}
+
+func TestComputeShuffledIndexSingle(t *testing.T) {
+	seed := sha256.Sum256(nil)
+	if got := computeShuffledIndex(0, 1, seed, 90); got != 0 {
+		t.Fatalf("only index shuffled to %d", got)
+	}
+}